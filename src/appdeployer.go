@@ -22,6 +22,8 @@ import (
   "path/filepath"
   "fmt"
   "bufio"
+  "time"
+  "io"
 )
 
 const (
@@ -82,12 +84,13 @@ type AppDeployer struct {
   targetExePath string
   destinationExePath string
   iconFilename string
+  report *DeployReport
+  config *ProjectConfig
+  runtimeRules []RuntimeDependencyRule
 }
 
-func (ad *AppDeployer) DeployApp() {
-  if err := ad.qtDeployer.queryQtEnv(); err != nil {
-    log.Println(err)
-  }
+func (ad *AppDeployer) DeployApp() error {
+  if err := ad.resolveQtEnvironment(); err != nil { return err }
 
   ad.waitGroup.Add(1)
   go ad.processMainExe()
@@ -103,6 +106,19 @@ func (ad *AppDeployer) DeployApp() {
   ad.waitGroup.Wait()
   log.Printf("Tasks have been processed")
 
+  // QtCore is deployed in the first pass and trace run
+  // has to load plugins from the AppDir, not from the host Qt
+  if (*qtConfFlag || ad.qtDeployer.qtConfRequired) && ad.qtDeployer.qtCoreDeployed {
+    if err := ad.generateQtConf(); err != nil { return err }
+  }
+
+  var tracedLibraries []string
+  if *traceRunFlag {
+    tracedLibraries = ad.traceRun()
+    // second pass for the libraries found by trace run
+    ad.waitGroup.Wait()
+  }
+
   close(ad.libsChannel)
   close(ad.copyChannel)
   close(ad.qtChannel)
@@ -113,10 +129,101 @@ func (ad *AppDeployer) DeployApp() {
   wg.Add(1)
   go ad.deployQtTranslations(filepath.Join(ad.destinationRoot, "translations"), &wg)
 
-  err := cleanupBlacklistedLibs(ad.LibsPath(), blacklist)
+  blacklisted, err := cleanupBlacklistedLibs(ad.LibsPath(), blacklist)
   if err != nil { log.Printf("Error while removing blacklisted libs: %v", err) }
+  ad.report.accountBlacklisted(blacklisted)
+  ad.accountTracedLibraries(tracedLibraries)
 
   wg.Wait()
+
+  // report shows what was deployed before the failure
+  if err := ad.qtDeployer.qtCorePatchError; err != nil {
+    ad.finishReport()
+    return err
+  }
+
+  if generateAppRun() && *appRunScriptFlag {
+    if err := ad.generateAppRunScript(); err != nil { return err }
+  }
+
+  ad.finishReport()
+
+  return ad.checkSizeBudget()
+}
+
+func (ad *AppDeployer) finishReport() {
+  ad.report.setPluginMetadata(ad.collectPluginMetadata())
+  ad.report.finish(ad.destinationRoot)
+
+  if !(*stdoutFlag) {
+    ad.report.printSummary(os.Stdout)
+  }
+
+  ad.report.printSummary(log.Writer())
+
+  if len(*reportPathFlag) > 0 {
+    if err := ad.report.writeJson(*reportPathFlag); err != nil {
+      log.Printf("Failed to write report to %v: %v", *reportPathFlag, err)
+    } else {
+      log.Printf("Report written to %v", *reportPathFlag)
+    }
+  }
+}
+
+// reads metadata of deployed plugins and warns about ones built with other Qt
+func (ad *AppDeployer) collectPluginMetadata() []QtPluginMetadata {
+  pluginsRoot := filepath.Join(ad.destinationRoot, "plugins")
+  qtVersion := ad.qtDeployer.qtEnv[QT_VERSION]
+  plugins := make([]QtPluginMetadata, 0)
+
+  filepath.Walk(pluginsRoot, func(path string, info os.FileInfo, err error) error {
+    if err != nil || !info.Mode().IsRegular() || !strings.Contains(info.Name(), ".so") { return nil }
+
+    metadata, err := readQtPluginMetadata(path)
+    if err != nil {
+      log.Printf("Cannot read metadata of %v: %v", path, err)
+      return nil
+    }
+
+    metadata.Path, _ = filepath.Rel(ad.destinationRoot, path)
+    if qtPluginVersionMismatch(metadata.QtVersion, qtVersion) {
+      log.Printf("Warning: plugin %v is built with Qt %v while deployed Qt is %v", metadata.Path, metadata.QtVersion, qtVersion)
+      metadata.VersionMismatch = true
+    }
+
+    plugins = append(plugins, *metadata)
+    return nil
+  })
+
+  return plugins
+}
+
+func (ad *AppDeployer) checkSizeBudget() error {
+  if maxAppDirSize <= 0 || ad.report.Sizes == nil { return nil }
+
+  sizes := ad.report.Sizes
+  if sizes.Total <= maxAppDirSize {
+    log.Printf("AppDir size %v is within budget of %v", formatSize(sizes.Total), formatSize(maxAppDirSize))
+    return nil
+  }
+
+  baseline := make(map[string]int64)
+  if len(*sizeBaselineFlag) > 0 {
+    var err error
+    if baseline, err = loadBaselineSizes(*sizeBaselineFlag); err != nil {
+      log.Printf("Cannot load size baseline %v: %v", *sizeBaselineFlag, err)
+      baseline = make(map[string]int64)
+    }
+  }
+
+  additions := sizes.newAdditions(baseline, 5)
+  descriptions := make([]string, 0, len(additions))
+  for _, entry := range additions {
+    descriptions = append(descriptions, fmt.Sprintf("%v (+%v)", entry.Path, formatSize(entry.Size)))
+  }
+
+  return fmt.Errorf("AppDir size %v exceeds budget of %v. Biggest additions: %v",
+    formatSize(sizes.Total), formatSize(maxAppDirSize), strings.Join(descriptions, ", "))
 }
 
 func (ad *AppDeployer) LibsPath() string {
@@ -162,8 +269,10 @@ func (ad *AppDeployer) processMainExe() {
 
   go ad.copyMainExe()
 
+  start := time.Now()
   dependencies, err := ad.findLddDependencies(filepath.Base(ad.targetExePath), ad.targetExePath)
   if err != nil { log.Fatal(err) }
+  ad.report.addStageTime(STAGE_LDD, start)
 
   for _, dependPath := range dependencies {
     if !ad.isLibraryDeployed(dependPath) {
@@ -184,7 +293,7 @@ func (ad *AppDeployer) copyMainExe() {
 
   err := copyFile(ad.targetExePath, destinationPath)
   if err != nil {
-    log.Fatal("Error while copying main exe [%v] to [%v]: %v", ad.targetExePath, destinationPath, err)
+    log.Fatalf("Error while copying main exe [%v] to [%v]: %v", ad.targetExePath, destinationPath, err)
   }
 
   ad.destinationExePath = destinationPath
@@ -192,7 +301,7 @@ func (ad *AppDeployer) copyMainExe() {
 
   ad.addFixRPathTask(destinationPath)
 
-  if generateAppImg() {
+  if generateAppRun() {
     ad.createAppLink()
   }
 
@@ -204,6 +313,9 @@ func (ad *AppDeployer) copyMainExe() {
 }
 
 func (ad *AppDeployer) createAppLink() {
+  // script needs to know what was deployed so it's generated in the end
+  if *appRunScriptFlag { return }
+
   appname := filepath.Base(ad.destinationExePath)
   symlinkPath := filepath.Join(ad.destinationRoot, "AppRun")
   err := os.Symlink(appname, symlinkPath)
@@ -251,33 +363,38 @@ func (ad *AppDeployer) generateDesktopFile() {
   writer := bufio.NewWriter(desktopFile)
   defer desktopFile.Close()
 
-  fmt.Fprintln(writer, "[Desktop Entry]")
-  fmt.Fprintln(writer, "Type=Application")
-  fmt.Fprintf(writer, "Name=%s\n", exeFilename)
-
   if generateAppImg() {
-    fmt.Fprintln(writer, "Exec=./AppRun %F")
+    icon := ""
     if len(ad.iconFilename) > 0 {
       extensionStartIndex := strings.LastIndex(ad.iconFilename, ".")
-      iconBasename := ad.iconFilename[:extensionStartIndex]
-      fmt.Fprintf(writer, "Icon=%s\n", iconBasename)
+      icon = ad.iconFilename[:extensionStartIndex]
     }
+    writeDesktopEntry(writer, exeFilename, "./AppRun", icon)
   } else {
-    fmt.Fprintf(writer, "Exec=%s\n", exeFilename)
-    if len(ad.iconFilename) > 0 {
-      fmt.Fprintf(writer, "Icon=%s\n", ad.iconFilename)
-    }
+    // Exec has to be absolute or looked up in PATH, relative paths are not allowed
+    writeDesktopEntry(writer, exeFilename, exeFilename, ad.iconFilename)
   }
 
-  fmt.Fprintln(writer, "Terminal=false")
-  fmt.Fprintln(writer, "StartupNotify=true")
-  fmt.Fprintln(writer, "Encoding=UTF-8")
-
   writer.Flush()
 
   log.Println("Desktop file generated")
 }
 
+func writeDesktopEntry(writer io.Writer, name, execPath, icon string) {
+  fmt.Fprintln(writer, "[Desktop Entry]")
+  fmt.Fprintln(writer, "Type=Application")
+  fmt.Fprintf(writer, "Name=%s\n", name)
+  fmt.Fprintf(writer, "Exec=%s %%F\n", execPath)
+
+  if len(icon) > 0 {
+    fmt.Fprintf(writer, "Icon=%s\n", icon)
+  }
+
+  fmt.Fprintln(writer, "Terminal=false")
+  fmt.Fprintln(writer, "StartupNotify=true")
+  fmt.Fprintln(writer, "Encoding=UTF-8")
+}
+
 func (ad *AppDeployer) addFixRPathTask(fullpath string) {
   ad.waitGroup.Add(1)
   go func() {
@@ -340,6 +457,7 @@ func (ad *AppDeployer) deployRecursively(sourceRoot, sourcePath, targetPath stri
   log.Printf("Deploying recursively %v in %v to %v", sourcePath, sourceRoot, targetPath)
 
   onlyLibraries := flags.HasFlag(DEPLOY_ONLY_LIBRARIES_FLAG)
+  isPlugins := sourceRoot == ad.qtDeployer.PluginsPath()
   var emptyFlags Bitmask = 0
 
   err := filepath.Walk(rootpath, func(path string, info os.FileInfo, err error) error {
@@ -363,6 +481,20 @@ func (ad *AppDeployer) deployRecursively(sourceRoot, sourcePath, targetPath stri
       log.Println(err)
     }
 
+    if isPlugins && isLibrary && !qtPluginSelection.isSelected(relativePath) {
+      log.Printf("Skipping Qt plugin %v due to -qt-plugins selection", relativePath)
+      return nil
+    }
+
+    if isPlugins && isLibrary && len(imageFormats) > 0 && filepath.Dir(relativePath) == "imageformats" {
+      if metadata, err := readQtPluginMetadata(path); err != nil {
+        log.Printf("Cannot read metadata of %v: %v", relativePath, err)
+      } else if !metadata.supportsAnyFormat(imageFormats) {
+        log.Printf("Skipping Qt plugin %v for formats %v not used by the app", relativePath, metadata.Keys)
+        return nil
+      }
+    }
+
     if isLibrary {
       ad.addLibTask(sourceRoot, relativePath, targetPath, flags | LDD_DEPENDENCY_FLAG)
     } else {
@@ -0,0 +1,190 @@
+/*
+ * This file is a part of linuxdeploy - tool for
+ * creating standalone applications for Linux
+ *
+ * Copyright (C) 2017 Taras Kushnir <kushnirTV@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the MIT License.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ */
+
+package main
+
+import (
+  "log"
+  "os"
+  "os/exec"
+  "io"
+  "fmt"
+  "bytes"
+  "errors"
+  "debug/elf"
+  "io/ioutil"
+  "path/filepath"
+)
+
+const (
+  appImageRuntimeName = "runtime"
+)
+
+var appImageCompressions = map[string]bool {
+  "gzip": true,
+  "xz": true,
+  "zstd": true,
+  "lzo": true,
+  "lz4": true,
+}
+
+func (ad *AppDeployer) createAppImage() error {
+  // AppImage is the default output, so missing tools fail deployment only if it was requested
+  required := appImageRequested || len(*appImageRuntimeFlag) > 0
+
+  mksquashfsPath, err := exec.LookPath("mksquashfs")
+  if err != nil {
+    if required { return errors.New("mksquashfs cannot be found, it is required to create AppImage") }
+    log.Printf("Warning: mksquashfs cannot be found, AppDir %v is not packed into AppImage", ad.destinationRoot)
+    return nil
+  }
+
+  runtimePath, err := resolveAppImageRuntime()
+  if err != nil {
+    if required { return err }
+    log.Printf("Warning: %v. AppDir %v is not packed into AppImage", err, ad.destinationRoot)
+    return nil
+  }
+
+  runtime, err := readAppImageRuntime(runtimePath)
+  if err != nil { return err }
+
+  outputPath := ad.outputPath(*appImageOutputFlag, ".AppImage")
+  log.Printf("Creating AppImage %v from %v using runtime %v", outputPath, ad.destinationRoot, runtimePath)
+
+  squashfsFile, err := ioutil.TempFile(filepath.Dir(outputPath), ".linuxdeploy-squashfs-")
+  if err != nil { return err }
+  squashfsPath := squashfsFile.Name()
+  squashfsFile.Close()
+  defer os.Remove(squashfsPath)
+
+  out, err := exec.Command(mksquashfsPath, ad.destinationRoot, squashfsPath,
+    "-root-owned", "-noappend", "-comp", *appImageCompressionFlag).CombinedOutput()
+  if err != nil {
+    log.Printf("mksquashfs output: %s", out)
+    return fmt.Errorf("mksquashfs failed: %v", err)
+  }
+
+  if err = writeAppImage(outputPath, runtime, squashfsPath); err != nil {
+    os.Remove(outputPath)
+    return err
+  }
+
+  log.Printf("AppImage created at %v", outputPath)
+  return nil
+}
+
+// runtime from the flag or the one shipped next to linuxdeploy
+func resolveAppImageRuntime() (string, error) {
+  if len(*appImageRuntimeFlag) > 0 {
+    if _, err := os.Stat(*appImageRuntimeFlag); err != nil { return "", err }
+    return *appImageRuntimeFlag, nil
+  }
+
+  if len(currentExeFullPath) > 0 {
+    bundledPath := filepath.Join(filepath.Dir(currentExeFullPath), appImageRuntimeName)
+    if _, err := os.Stat(bundledPath); err == nil {
+      return bundledPath, nil
+    }
+  }
+
+  return "", errors.New("AppImage runtime cannot be found. Please specify it with -appimage-runtime")
+}
+
+// reads the runtime ELF without anything that might be appended after it
+func readAppImageRuntime(path string) ([]byte, error) {
+  contents, err := ioutil.ReadFile(path)
+  if err != nil { return nil, err }
+
+  f, err := elf.NewFile(bytes.NewReader(contents))
+  if err != nil { return nil, fmt.Errorf("AppImage runtime %v is not an ELF file: %v", path, err) }
+  defer f.Close()
+
+  elfSize, err := elfFileSize(contents, f)
+  if err != nil { return nil, err }
+
+  if elfSize < int64(len(contents)) {
+    log.Printf("Runtime %v has %v bytes after the ELF, ignoring them", path, int64(len(contents)) - elfSize)
+    contents = contents[:elfSize]
+  }
+
+  // AppImage type 2 magic bytes
+  if len(contents) < 11 || !bytes.Equal(contents[8:11], []byte("AI\x02")) {
+    log.Printf("Warning: runtime %v does not have AppImage type 2 magic bytes", path)
+  }
+
+  return contents, nil
+}
+
+// the runtime looks for the embedded filesystem right after its section headers
+func elfFileSize(contents []byte, f *elf.File) (int64, error) {
+  var shoff, shentsize, shnum int64
+
+  switch f.Class {
+  case elf.ELFCLASS64:
+    if len(contents) < 64 { return 0, errors.New("ELF header is truncated") }
+    shoff = int64(f.ByteOrder.Uint64(contents[40:48]))
+    shentsize = int64(f.ByteOrder.Uint16(contents[58:60]))
+    shnum = int64(f.ByteOrder.Uint16(contents[60:62]))
+  case elf.ELFCLASS32:
+    if len(contents) < 52 { return 0, errors.New("ELF header is truncated") }
+    shoff = int64(f.ByteOrder.Uint32(contents[32:36]))
+    shentsize = int64(f.ByteOrder.Uint16(contents[46:48]))
+    shnum = int64(f.ByteOrder.Uint16(contents[48:50]))
+  default:
+    return 0, errors.New("Unknown ELF class")
+  }
+
+  size := shoff + shentsize * shnum
+
+  // some linkers put section headers before the data
+  for _, section := range f.Sections {
+    if section.Type == elf.SHT_NOBITS { continue }
+    if end := int64(section.Offset + section.FileSize); end > size { size = end }
+  }
+
+  for _, prog := range f.Progs {
+    if end := int64(prog.Off + prog.Filesz); end > size { size = end }
+  }
+
+  if size > int64(len(contents)) { return 0, errors.New("ELF is bigger than the file") }
+
+  return size, nil
+}
+
+func writeAppImage(outputPath string, runtime []byte, squashfsPath string) (err error) {
+  out, err := os.OpenFile(outputPath, os.O_RDWR | os.O_TRUNC | os.O_CREATE, 0755)
+  if err != nil { return err }
+
+  defer func() {
+    cerr := out.Close()
+    if err == nil {
+      err = cerr
+    }
+  }()
+
+  if _, err = out.Write(runtime); err != nil { return err }
+
+  squashfs, err := os.Open(squashfsPath)
+  if err != nil { return err }
+  defer squashfs.Close()
+
+  log.Printf("Squashfs offset in AppImage is %v", len(runtime))
+  if _, err = io.Copy(out, squashfs); err != nil { return err }
+
+  // umask might have dropped executable bits
+  if err = out.Chmod(0755); err != nil { return err }
+
+  return out.Sync()
+}
@@ -21,6 +21,7 @@ import (
   "strings"
   "os"
   "fmt"
+  "time"
 )
 
 func (ad *AppDeployer) processLibTasks() {
@@ -29,7 +30,9 @@ func (ad *AppDeployer) processLibTasks() {
   }
 
   for request := range ad.libsChannel {
+    start := time.Now()
     ad.processLibTask(request)
+    ad.report.addStageTime(STAGE_LDD, start)
     ad.waitGroup.Done()
   }
 
@@ -41,6 +44,13 @@ func (ad *AppDeployer) processLibTask(request *DeployRequest) {
 
   if ad.canSkipLibrary(libpath) {
     log.Printf("Skipping library: %v", libpath)
+    ad.report.accountSkipped()
+    return
+  }
+
+  if !ad.applyRuntimeDependencyRules(libpath) {
+    ad.accountLibrary(libpath)
+    ad.report.accountSkipped()
     return
   }
 
@@ -49,6 +59,7 @@ func (ad *AppDeployer) processLibTask(request *DeployRequest) {
   dependencies, err := ad.findLddDependencies(request.Basename(), libpath)
   if err != nil {
     log.Printf("Error while dependency check for %v: %v", libpath, err)
+    ad.report.accountFailed()
     return
   }
 
@@ -158,7 +169,9 @@ func (ad *AppDeployer) processCopyTasks() {
   copiedFiles := make(map[string]bool)
 
   for copyRequest := range ad.copyChannel {
+    start := time.Now()
     ad.processCopyTask(copiedFiles, copyRequest)
+    ad.report.addStageTime(STAGE_COPY, start)
     ad.waitGroup.Done()
   }
 
@@ -180,6 +193,7 @@ func (ad *AppDeployer) processCopyTask(copiedFiles map[string]bool, copyRequest
 
   if _, ok := copiedFiles[destinationPath]; ok {
     log.Printf("File %v has already been copied", sourcePath)
+    ad.report.accountSkipped()
     return
   }
 
@@ -188,10 +202,12 @@ func (ad *AppDeployer) processCopyTask(copiedFiles map[string]bool, copyRequest
 
   if err != nil {
     log.Printf("Error while copying [%v] to [%v]: %v", sourcePath, destinationPath, err)
+    ad.report.accountFailed()
     return
   }
 
   copiedFiles[destinationPath] = true
+  ad.report.accountDeployed(categorizeTarget(destinationPrefix))
   log.Printf("Copied [%v] to [%v]", sourcePath, destinationPath)
   isQtLibrary := false
 
@@ -222,15 +238,20 @@ func (ad *AppDeployer) processFixRPathTasks() {
   fixedFiles := make(map[string]bool)
 
   for fullpath := range ad.rpathChannel {
+    start := time.Now()
+
     if patchelfAvailable {
       if _, ok := fixedFiles[fullpath]; !ok {
-        fixRPath(fullpath, destinationRoot)
+        if err := fixRPath(fullpath, destinationRoot); err != nil {
+          ad.report.accountFailed()
+        }
         fixedFiles[fullpath] = true
       } else {
         log.Printf("RPATH has been already fixed for %v", fullpath)
       }
     }
 
+    ad.report.addStageTime(STAGE_RPATH, start)
     ad.addStripTask(fullpath)
 
     ad.waitGroup.Done()
@@ -239,12 +260,12 @@ func (ad *AppDeployer) processFixRPathTasks() {
   log.Printf("RPath change requests processing finished")
 }
 
-func fixRPath(fullpath, destinationRoot string) {
+func fixRPath(fullpath, destinationRoot string) error {
   libdir := filepath.Dir(fullpath)
   relativePath, err := filepath.Rel(libdir, destinationRoot)
   if err != nil {
     log.Println(err)
-    return
+    return err
   }
 
   rpath := fmt.Sprintf("$ORIGIN:$ORIGIN/%s/lib/", relativePath)
@@ -254,6 +275,8 @@ func fixRPath(fullpath, destinationRoot string) {
   if err = cmd.Run(); err != nil {
     log.Println(err)
   }
+
+  return err
 }
 
 func (ad *AppDeployer) addStripTask(fullpath string) {
@@ -276,16 +299,22 @@ func (ad *AppDeployer) processStripTasks() {
   strippedBinaries := make(map[string]bool)
 
   for fullpath := range ad.stripChannel {
+    start := time.Now()
+
     if stripAvailable {
       if _, ok := strippedBinaries[fullpath]; !ok {
         if err := stripBinary(fullpath); err == nil {
           strippedBinaries[fullpath] = true
+        } else {
+          ad.report.accountFailed()
         }
       } else {
         log.Printf("%v has been already stripped", fullpath)
       }
     }
 
+    ad.report.addStageTime(STAGE_STRIP, start)
+
     ad.waitGroup.Done()
   }
 
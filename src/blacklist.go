@@ -116,15 +116,15 @@ func parseBlacklistFile(filepath string) ([]string, error) {
   return blacklist, nil
 }
 
-func cleanupBlacklistedLibs(libdirpath string, blacklist []string) error {
+func cleanupBlacklistedLibs(libdirpath string, blacklist []string) (removed int, err error) {
   if len(blacklist) == 0 {
     log.Printf("No libraries blacklisted")
-    return nil
+    return 0, nil
   }
 
   log.Println("Removing blacklisted libraries...")
 
-  err := filepath.Walk(libdirpath, func(path string, info os.FileInfo, err error) error {
+  err = filepath.Walk(libdirpath, func(path string, info os.FileInfo, err error) error {
     if err != nil {
       return err
     }
@@ -138,7 +138,9 @@ func cleanupBlacklistedLibs(libdirpath string, blacklist []string) error {
     for _, blackLib := range blacklist {
       if strings.HasPrefix(basename, blackLib) {
         log.Printf("Removing blacklisted library [%v] with match on [%v]", path, blackLib)
-        os.Remove(path)
+        if os.Remove(path) == nil {
+          removed++
+        }
         break
       }
     }
@@ -146,5 +148,5 @@ func cleanupBlacklistedLibs(libdirpath string, blacklist []string) error {
     return nil
   })
 
-  return err
+  return removed, err
 }
@@ -0,0 +1,113 @@
+/*
+ * This file is a part of linuxdeploy - tool for
+ * creating standalone applications for Linux
+ *
+ * Copyright (C) 2017 Taras Kushnir <kushnirTV@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the MIT License.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ */
+
+package main
+
+import (
+  "log"
+  "fmt"
+  "regexp"
+  "strings"
+  "io/ioutil"
+  "encoding/json"
+)
+
+// project-wide settings which are too verbose for the command line
+type ProjectConfig struct {
+  Package PackageMetadata `json:"package"`
+  // additional variables exported by launchers, values can refer to $APPDIR
+  Environment map[string]string `json:"environment"`
+  // extends or overrides built-in rules in qtplugins.go
+  QtPluginRules []QtPluginRule `json:"qt_plugin_rules"`
+  // extends or overrides built-in rules in runtimedeps.go
+  RuntimeDependencies []RuntimeDependencyRule `json:"runtime_dependencies"`
+}
+
+type PackageMetadata struct {
+  Name string `json:"name"`
+  Version string `json:"version"`
+  Release string `json:"release"`
+  Maintainer string `json:"maintainer"`
+  Description string `json:"description"`
+  Homepage string `json:"homepage"`
+  License string `json:"license"`
+}
+
+var shellIdentifierRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+const (
+  defaultPackageVersion = "1.0"
+  defaultPackageMaintainer = "Unknown <unknown@localhost>"
+)
+
+func loadProjectConfig(path string) (*ProjectConfig, error) {
+  config := &ProjectConfig{}
+  if len(path) == 0 { return config, nil }
+
+  log.Printf("Loading project config %v", path)
+
+  data, err := ioutil.ReadFile(path)
+  if err != nil { return nil, err }
+
+  if err = json.Unmarshal(data, config); err != nil { return nil, err }
+
+  // names are written unquoted into launcher scripts
+  for name := range config.Environment {
+    if !shellIdentifierRegexp.MatchString(name) {
+      return nil, fmt.Errorf("Environment variable name %q in %v is not a valid shell identifier", name, path)
+    }
+  }
+
+  return config, nil
+}
+
+// metadata from the config overridden by the cmdline flags
+func (ad *AppDeployer) packageMetadata() PackageMetadata {
+  metadata := ad.config.Package
+
+  overrideString(&metadata.Name, *packageNameFlag)
+  overrideString(&metadata.Version, *packageVersionFlag)
+  overrideString(&metadata.Maintainer, *packageMaintainerFlag)
+  overrideString(&metadata.Description, *packageDescriptionFlag)
+
+  if len(metadata.Name) == 0 { metadata.Name = ad.appName() }
+  metadata.Name = sanitizePackageName(metadata.Name)
+
+  if len(metadata.Version) == 0 {
+    log.Printf("Package version is not set, using %v", defaultPackageVersion)
+    metadata.Version = defaultPackageVersion
+  }
+
+  if len(metadata.Release) == 0 { metadata.Release = "1" }
+  if len(metadata.Maintainer) == 0 { metadata.Maintainer = defaultPackageMaintainer }
+  if len(metadata.Description) == 0 { metadata.Description = metadata.Name }
+  if len(metadata.License) == 0 { metadata.License = "Proprietary" }
+
+  return metadata
+}
+
+func overrideString(value *string, override string) {
+  if len(override) > 0 { *value = override }
+}
+
+// package names can only contain lowercase letters, digits and [+-.]
+func sanitizePackageName(name string) string {
+  return strings.Map(func(r rune) rune {
+    switch {
+    case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '+', r == '-', r == '.': return r
+    case r >= 'A' && r <= 'Z': return r - 'A' + 'a'
+    }
+    return '-'
+  }, name)
+}
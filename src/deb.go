@@ -0,0 +1,334 @@
+/*
+ * This file is a part of linuxdeploy - tool for
+ * creating standalone applications for Linux
+ *
+ * Copyright (C) 2017 Taras Kushnir <kushnirTV@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the MIT License.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ */
+
+package main
+
+import (
+  "log"
+  "os"
+  "io"
+  "fmt"
+  "bytes"
+  "time"
+  "strings"
+  "crypto/md5"
+  "debug/elf"
+  "archive/tar"
+  "compress/gzip"
+  "io/ioutil"
+  "path"
+  "path/filepath"
+)
+
+// files which are installed outside of the deployed tree
+type packageExtraFile struct {
+  path string // absolute path in the target system
+  contents []byte
+  link string // symlink target if not empty
+}
+
+func (ad *AppDeployer) createDebPackage() error {
+  metadata := ad.packageMetadata()
+
+  launcherPath := filepath.Join("bin", ad.appName())
+  if err := ad.generateLauncherScript(launcherPath); err != nil { return err }
+
+  machine, err := elfMachine(ad.targetExePath)
+  if err != nil { return err }
+  arch := debArchitecture(machine)
+
+  installRoot := path.Join("/opt", metadata.Name)
+  extraFiles := ad.systemIntegrationFiles(metadata, installRoot)
+
+  outputPath := ad.packageOutputPath(fmt.Sprintf("%s_%s-%s_%s.deb", metadata.Name, metadata.Version, metadata.Release, arch))
+
+  log.Printf("Creating Debian package %v", outputPath)
+
+  dataFile, err := ioutil.TempFile(filepath.Dir(outputPath), ".linuxdeploy-data-")
+  if err != nil { return err }
+  defer os.Remove(dataFile.Name())
+  defer dataFile.Close()
+
+  md5sums := &bytes.Buffer{}
+  if err = ad.writeDebData(dataFile, installRoot, extraFiles, md5sums); err != nil { return err }
+
+  sizes, err := analyzeSizes(ad.destinationRoot)
+  if err != nil { return err }
+
+  control := &bytes.Buffer{}
+  fmt.Fprintf(control, "Package: %s\n", metadata.Name)
+  fmt.Fprintf(control, "Version: %s-%s\n", metadata.Version, metadata.Release)
+  fmt.Fprintf(control, "Architecture: %s\n", arch)
+  fmt.Fprintf(control, "Maintainer: %s\n", metadata.Maintainer)
+  fmt.Fprintf(control, "Installed-Size: %d\n", (sizes.Total + 1023) / 1024)
+  fmt.Fprintln(control, "Section: misc")
+  fmt.Fprintln(control, "Priority: optional")
+  if len(metadata.Homepage) > 0 {
+    fmt.Fprintf(control, "Homepage: %s\n", metadata.Homepage)
+  }
+  fmt.Fprintf(control, "Description: %s\n", debDescription(metadata.Description))
+
+  controlArchive, err := debControlArchive(control.Bytes(), md5sums.Bytes())
+  if err != nil { return err }
+
+  if err = writeDebArchive(outputPath, controlArchive, dataFile); err != nil {
+    os.Remove(outputPath)
+    return err
+  }
+
+  log.Printf("Debian package created at %v", outputPath)
+  return nil
+}
+
+func debArchitecture(machine elf.Machine) string {
+  switch machine {
+  case elf.EM_X86_64: return "amd64"
+  case elf.EM_386: return "i386"
+  case elf.EM_AARCH64: return "arm64"
+  case elf.EM_ARM: return "armhf"
+  case elf.EM_PPC64: return "ppc64el"
+  case elf.EM_S390: return "s390x"
+  }
+
+  return strings.ToLower(strings.TrimPrefix(machine.String(), "EM_"))
+}
+
+// first line is a synopsis and the rest is indented extended description
+func debDescription(description string) string {
+  lines := strings.Split(strings.TrimSpace(description), "\n")
+  for i := 1; i < len(lines); i++ {
+    line := strings.TrimSpace(lines[i])
+    if len(line) == 0 { line = "." }
+    lines[i] = " " + line
+  }
+
+  return strings.Join(lines, "\n")
+}
+
+// desktop file, icon and symlink to the launcher for the system package
+func (ad *AppDeployer) systemIntegrationFiles(metadata PackageMetadata, installRoot string) []packageExtraFile {
+  files := make([]packageExtraFile, 0, 3)
+  launcher := path.Join(installRoot, "bin", ad.appName())
+
+  files = append(files, packageExtraFile{
+    path: path.Join("/usr/bin", metadata.Name),
+    link: launcher,
+  })
+
+  icon := ""
+  if len(ad.iconFilename) > 0 {
+    iconSource := filepath.Join(ad.destinationRoot, ad.iconFilename)
+    if contents, err := ioutil.ReadFile(iconSource); err == nil {
+      extension := strings.ToLower(filepath.Ext(ad.iconFilename))
+      icon = metadata.Name
+      files = append(files, packageExtraFile{
+        path: path.Join("/usr/share/icons/hicolor", iconThemeSize(iconSource), "apps", icon + extension),
+        contents: contents,
+      })
+    } else {
+      log.Printf("Cannot read icon %v: %v", iconSource, err)
+    }
+  }
+
+  desktop := &bytes.Buffer{}
+  writeDesktopEntry(desktop, ad.appName(), launcher, icon)
+  files = append(files, packageExtraFile{
+    path: path.Join("/usr/share/applications", metadata.Name + ".desktop"),
+    contents: desktop.Bytes(),
+  })
+
+  return files
+}
+
+func (ad *AppDeployer) writeDebData(w io.Writer, installRoot string, extraFiles []packageExtraFile, md5sums io.Writer) error {
+  gw := gzip.NewWriter(w)
+  tw := tar.NewWriter(gw)
+  now := time.Now()
+
+  dirs := newTarDirs(tw, "./", now)
+  if err := dirs.ensure(path.Dir(installRoot)); err != nil { return err }
+  if err := addTreeToTar(tw, ad.destinationRoot, "." + installRoot); err != nil { return err }
+
+  err := filepath.Walk(ad.destinationRoot, func(fullpath string, info os.FileInfo, err error) error {
+    if err != nil || !info.Mode().IsRegular() { return err }
+
+    relativePath, err := filepath.Rel(ad.destinationRoot, fullpath)
+    if err != nil { return err }
+
+    sum, err := md5File(fullpath)
+    if err != nil { return err }
+
+    fmt.Fprintf(md5sums, "%x  %s\n", sum, path.Join(installRoot, filepath.ToSlash(relativePath))[1:])
+    return nil
+  })
+  if err != nil { return err }
+
+  for _, extra := range extraFiles {
+    if err = dirs.ensure(path.Dir(extra.path)); err != nil { return err }
+    if err = addExtraFileToTar(tw, "." + extra.path, extra, now); err != nil { return err }
+
+    if len(extra.link) == 0 {
+      fmt.Fprintf(md5sums, "%x  %s\n", md5.Sum(extra.contents), extra.path[1:])
+    }
+  }
+
+  if err = tw.Close(); err != nil { return err }
+  return gw.Close()
+}
+
+func debControlArchive(control, md5sums []byte) ([]byte, error) {
+  buffer := &bytes.Buffer{}
+  gw := gzip.NewWriter(buffer)
+  tw := tar.NewWriter(gw)
+  now := time.Now()
+
+  if err := newTarDirs(tw, "./", now).ensure("/"); err != nil { return nil, err }
+
+  files := []packageExtraFile {
+    { path: "./control", contents: control },
+    { path: "./md5sums", contents: md5sums },
+  }
+
+  for _, file := range files {
+    if err := addExtraFileToTar(tw, file.path, file, now); err != nil { return nil, err }
+  }
+
+  if err := tw.Close(); err != nil { return nil, err }
+  if err := gw.Close(); err != nil { return nil, err }
+
+  return buffer.Bytes(), nil
+}
+
+func writeDebArchive(outputPath string, controlArchive []byte, dataFile *os.File) (err error) {
+  out, err := os.OpenFile(outputPath, os.O_RDWR | os.O_TRUNC | os.O_CREATE, 0644)
+  if err != nil { return err }
+
+  defer func() {
+    cerr := out.Close()
+    if err == nil {
+      err = cerr
+    }
+  }()
+
+  dataInfo, err := dataFile.Stat()
+  if err != nil { return err }
+  if _, err = dataFile.Seek(0, io.SeekStart); err != nil { return err }
+
+  ar := newArWriter(out)
+  if err = ar.writeEntry("debian-binary", bytes.NewReader([]byte("2.0\n")), 4); err != nil { return err }
+  if err = ar.writeEntry("control.tar.gz", bytes.NewReader(controlArchive), int64(len(controlArchive))); err != nil { return err }
+  if err = ar.writeEntry("data.tar.gz", dataFile, dataInfo.Size()); err != nil { return err }
+
+  return nil
+}
+
+type arWriter struct {
+  w io.Writer
+  mtime int64
+}
+
+func newArWriter(w io.Writer) *arWriter {
+  io.WriteString(w, "!<arch>\n")
+  return &arWriter{w: w, mtime: time.Now().Unix()}
+}
+
+func (ar *arWriter) writeEntry(name string, contents io.Reader, size int64) error {
+  header := fmt.Sprintf("%-16s%-12d%-6d%-6d%-8o%-10d`\n", name, ar.mtime, 0, 0, 0100644, size)
+  if _, err := io.WriteString(ar.w, header); err != nil { return err }
+
+  written, err := io.Copy(ar.w, contents)
+  if err != nil { return err }
+  if written != size { return fmt.Errorf("Expected to write %v bytes of %v but written %v", size, name, written) }
+
+  // entries are aligned to even offsets
+  if size % 2 == 1 {
+    _, err = io.WriteString(ar.w, "\n")
+  }
+
+  return err
+}
+
+// adds parent directories to the archive only once
+type tarDirs struct {
+  tw *tar.Writer
+  prefix string
+  modTime time.Time
+  added map[string]bool
+}
+
+func newTarDirs(tw *tar.Writer, prefix string, modTime time.Time) *tarDirs {
+  return &tarDirs{tw: tw, prefix: prefix, modTime: modTime, added: make(map[string]bool)}
+}
+
+func (td *tarDirs) ensure(dir string) error {
+  dir = path.Clean("/" + dir)
+  if td.added[dir] { return nil }
+
+  if dir != "/" {
+    if err := td.ensure(path.Dir(dir)); err != nil { return err }
+  }
+
+  name := td.prefix + strings.TrimPrefix(dir, "/")
+  if !strings.HasSuffix(name, "/") { name += "/" }
+
+  td.added[dir] = true
+  // archives without prefix have no entry for the root
+  if name == "/" { return nil }
+
+  return td.tw.WriteHeader(&tar.Header{
+    Typeflag: tar.TypeDir,
+    Name: name,
+    Mode: 0755,
+    Uname: "root",
+    Gname: "root",
+    ModTime: td.modTime,
+    Format: tar.FormatPAX,
+  })
+}
+
+func addExtraFileToTar(tw *tar.Writer, name string, file packageExtraFile, modTime time.Time) error {
+  header := &tar.Header{
+    Name: name,
+    Mode: 0644,
+    Uname: "root",
+    Gname: "root",
+    ModTime: modTime,
+    Format: tar.FormatPAX,
+  }
+
+  if len(file.link) > 0 {
+    header.Typeflag = tar.TypeSymlink
+    header.Linkname = file.link
+    header.Mode = 0777
+    return tw.WriteHeader(header)
+  }
+
+  header.Typeflag = tar.TypeReg
+  header.Size = int64(len(file.contents))
+  if err := tw.WriteHeader(header); err != nil { return err }
+
+  _, err := tw.Write(file.contents)
+  return err
+}
+
+func md5File(fullpath string) ([]byte, error) {
+  f, err := os.Open(fullpath)
+  if err != nil { return nil, err }
+  defer f.Close()
+
+  hash := md5.New()
+  if _, err = io.Copy(hash, f); err != nil { return nil, err }
+
+  return hash.Sum(nil), nil
+}
@@ -0,0 +1,159 @@
+/*
+ * This file is a part of linuxdeploy - tool for
+ * creating standalone applications for Linux
+ *
+ * Copyright (C) 2017 Taras Kushnir <kushnirTV@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the MIT License.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ */
+
+package main
+
+import (
+  "log"
+  "os"
+  "fmt"
+  "sort"
+  "bufio"
+  "strings"
+  "path/filepath"
+)
+
+type launcherVariable struct {
+  name string
+  relativePath string // relative to the deployment root
+  prepend bool // keep previous value of the variable after ours
+  fallback string // previous value when the variable is not set
+  always bool // exported even if the deployed directory does not exist
+}
+
+var launcherVariables = []launcherVariable {
+  { "LD_LIBRARY_PATH", "lib", true, "", false },
+  { "QT_PLUGIN_PATH", "plugins", false, "", false },
+  { "QML2_IMPORT_PATH", "qml", false, "", false },
+  { "QTWEBENGINEPROCESS_PATH", "libexecs/QtWebEngineProcess", false, "", false },
+  { "QTWEBENGINE_RESOURCES_PATH", "resources", false, "", false },
+  { "QTWEBENGINE_LOCALES_PATH", "translations/qtwebengine_locales", false, "", false },
+  // modules deployed by runtime dependency rules
+  { "GST_PLUGIN_SYSTEM_PATH_1_0", "lib/gstreamer-1.0", false, "", false },
+  // defaults are from XDG Base Directory Specification, they have to be
+  // set explicitly since exporting our dir alone hides host icons and mime types
+  { "XDG_DATA_DIRS", "share", true, "/usr/local/share:/usr/share", true },
+}
+
+const appRunHeader = `#!/bin/sh
+# generated by linuxdeploy
+if [ -z "$APPDIR" ]; then
+  APPDIR="$(dirname "$(readlink -f "$0")")"
+fi
+export APPDIR
+
+# AppImage runtime passes the name the AppImage was started with
+if [ -z "$ARGV0" ]; then
+  ARGV0="$0"
+fi
+export ARGV0
+`
+
+// generates shell script which runs main exe with environment pointing to deployed files
+func (ad *AppDeployer) generateLauncherScript(relativeScriptPath string) error {
+  scriptPath := filepath.Join(ad.destinationRoot, relativeScriptPath)
+  ensureDirExists(scriptPath)
+
+  rootFromScript, err := filepath.Rel(filepath.Dir(scriptPath), ad.destinationRoot)
+  if err != nil { return err }
+
+  script, err := os.OpenFile(scriptPath, os.O_CREATE | os.O_RDWR | os.O_TRUNC, 0755)
+  if err != nil { return err }
+  defer script.Close()
+
+  writer := bufio.NewWriter(script)
+
+  fmt.Fprintln(writer, "#!/bin/sh")
+  fmt.Fprintln(writer, "# generated by " + appName)
+  fmt.Fprintln(writer, "HERE=\"$(dirname \"$(readlink -f \"$0\")\")\"")
+  fmt.Fprintf(writer, "APPDIR=\"$(cd \"$HERE/%s\" && pwd)\"\n", filepath.ToSlash(rootFromScript))
+
+  ad.writeLauncherEnvironment(writer, "$APPDIR")
+
+  fmt.Fprintf(writer, "exec \"$APPDIR/%s\" \"$@\"\n", filepath.Base(ad.destinationExePath))
+
+  if err = writer.Flush(); err != nil { return err }
+
+  log.Printf("Generated launcher script %v", scriptPath)
+  return nil
+}
+
+// generates AppRun script which works both inside of AppImage and in unpacked AppDir
+func (ad *AppDeployer) generateAppRunScript() error {
+  scriptPath := filepath.Join(ad.destinationRoot, "AppRun")
+  os.Remove(scriptPath)
+
+  script, err := os.OpenFile(scriptPath, os.O_CREATE | os.O_RDWR | os.O_TRUNC, 0755)
+  if err != nil { return err }
+  defer script.Close()
+
+  writer := bufio.NewWriter(script)
+
+  fmt.Fprint(writer, appRunHeader)
+  ad.writeLauncherEnvironment(writer, "$APPDIR")
+
+  // working directory is not changed so relative paths in arguments
+  // (e.g. from desktop file's %F) are resolved by the app as usual
+  fmt.Fprintf(writer, "exec \"$APPDIR/%s\" \"$@\"\n", filepath.Base(ad.destinationExePath))
+
+  if err = writer.Flush(); err != nil { return err }
+
+  log.Printf("Generated AppRun script %v", scriptPath)
+  return nil
+}
+
+// variables for the deployed directories which exist and the ones exported always
+func (ad *AppDeployer) deployedLauncherVariables() []launcherVariable {
+  variables := make([]launcherVariable, 0, len(launcherVariables))
+
+  for _, variable := range launcherVariables {
+    if variable.always {
+      variables = append(variables, variable)
+    } else if _, err := os.Stat(filepath.Join(ad.destinationRoot, variable.relativePath)); err == nil {
+      variables = append(variables, variable)
+    }
+  }
+
+  return variables
+}
+
+func (ad *AppDeployer) writeLauncherEnvironment(writer *bufio.Writer, rootVariable string) {
+  for _, variable := range ad.deployedLauncherVariables() {
+    value := fmt.Sprintf("%s/%s", rootVariable, variable.relativePath)
+    if variable.prepend && len(variable.fallback) > 0 {
+      fmt.Fprintf(writer, "export %s=\"%s:${%s:-%s}\"\n", variable.name, value, variable.name, variable.fallback)
+    } else if variable.prepend {
+      fmt.Fprintf(writer, "export %s=\"%s${%s:+:$%s}\"\n", variable.name, value, variable.name, variable.name)
+    } else {
+      fmt.Fprintf(writer, "export %s=\"%s\"\n", variable.name, value)
+    }
+  }
+
+  environment := ad.config.Environment
+  names := make([]string, 0, len(environment))
+  for name := range environment {
+    names = append(names, name)
+  }
+  sort.Strings(names)
+
+  for _, name := range names {
+    // $APPDIR in values is expanded by the shell
+    fmt.Fprintf(writer, "export %s=\"%s\"\n", name, escapeShellValue(environment[name]))
+  }
+}
+
+// escapes characters special inside double quotes except $
+func escapeShellValue(value string) string {
+  return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "`", "\\`").Replace(value)
+}
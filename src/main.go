@@ -22,6 +22,8 @@ import (
   "os/exec"
   "io"
   "errors"
+  "strings"
+  "time"
   "path/filepath"
 )
 
@@ -40,11 +42,17 @@ var (
   qmlImports stringsParam
   librariesDirs stringsParam
   currentExeFullPath string
+  maxAppDirSize int64
+  qtPluginsSpecs stringsParam
+  qtPluginSelection PluginSelection
+  imageFormats map[string]bool
+  // -out appimage was passed explicitly instead of being the default
+  appImageRequested bool
 )
 
 // flags
 var (
-  outTypeFlag = flag.String("out", "appimage", "Type of the generated output")
+  outTypeFlag = flag.String("out", "appimage", "Type of the generated output (appimage, tarball, dir, deb, rpm, run, oci)")
   blacklistFileFlag = flag.String("blacklist", "libs.blacklist", "Path to the additional libraries blacklist file")
   defaultBlackListFlag = flag.Bool("default-blacklist", false, "Add default blacklist")
   generateDesktopFlag = flag.Bool("gen-desktop", false, "Generate desktop file")
@@ -54,17 +62,54 @@ var (
   iconPathFlag = flag.String("icon", "", "Path the exe's icon (used for desktop file)")
   appDirPathFlag = flag.String("appdir", "", "Path to the AppDir (if 'type' is appimage)")
   overwriteFlag = flag.Bool("overwrite", false, "Overwrite output if present")
-  qmakePathFlag = flag.String("qmake", "", "Path to qmake")
+  qmakePathFlag = flag.String("qmake", "", "Path to qmake or qtpaths")
   stripFlag = flag.Bool("strip", false, "Run strip on binaries")
+  reportPathFlag = flag.String("report", "", "Path to the JSON deployment report")
+  maxSizeFlag = flag.String("max-size", "", "Fail if AppDir is bigger than this size (e.g. 200M)")
+  appImageRuntimeFlag = flag.String("appimage-runtime", "", "Path to the AppImage runtime (default is 'runtime' next to linuxdeploy)")
+  appImageOutputFlag = flag.String("appimage-output", "", "Path to the generated AppImage file")
+  appImageCompressionFlag = flag.String("appimage-compression", "gzip", "Compression of the AppImage filesystem (gzip, xz, zstd, lzo, lz4)")
+  tarballOutputFlag = flag.String("tarball-output", "", "Path to the generated tarball")
+  tarballRootFlag = flag.String("tarball-root", "", "Name of the top-level directory in the tarball (default is exe name)")
+  tarballCompressionFlag = flag.String("tarball-compression", "gzip", "Compression of the tarball (gzip, xz, none)")
+  configPathFlag = flag.String("config", "", "Path to the JSON project config")
+  packageNameFlag = flag.String("pkg-name", "", "Package name (default is exe name)")
+  packageVersionFlag = flag.String("pkg-version", "", "Package version")
+  packageMaintainerFlag = flag.String("pkg-maintainer", "", "Package maintainer")
+  packageDescriptionFlag = flag.String("pkg-description", "", "Package description")
+  packageOutputFlag = flag.String("pkg-output", "", "Path to the generated package")
+  runDesktopHookFlag = flag.Bool("run-desktop-hook", false, "Install desktop file and icon after extraction of the self-extracting installer")
+  appRunScriptFlag = flag.Bool("apprun-script", false, "Generate AppRun script which sets up environment instead of symlink to the exe")
+  imageFormatsFlag = flag.String("image-formats", "", "Image formats used by the app, e.g. png,jpeg,svg. Other imageformats plugins are skipped")
+  noOpenSslFlag = flag.Bool("no-openssl", false, "Do not deploy OpenSSL libraries which QtNetwork loads at runtime")
+  waylandFlag = flag.Bool("wayland", false, "Deploy Wayland platform plugins and shell integrations")
+  qtConfFlag = flag.Bool("qt-conf", false, "Generate qt.conf with deployed paths instead of patching QtCore")
+  patchQtCoreFlag = flag.Bool("patch-qtcore", false, "Patch paths in QtCore even if qt.conf is generated")
+  skipQmlDesignerFlag = flag.Bool("skip-qml-designer", false, "Do not deploy designer dirs and .qmltypes files of QML modules")
+  traceRunFlag = flag.Bool("trace-run", false, "Run deployed exe with LD_DEBUG and deploy libraries it loads from the host")
+  traceArgsFlag = flag.String("trace-args", "", "Arguments of the deployed exe for -trace-run")
+  traceTimeoutFlag = flag.Duration("trace-timeout", 10 * time.Second, "Time after which -trace-run stops the exe")
+  sizeBaselineFlag = flag.String("size-baseline", "", "Path to the JSON report of previous deployment to compare sizes with")
 )
 
 const (
   appName = "linuxdeploy"
 )
 
+var supportedOutputTypes = map[string]bool {
+  "appimage": true,
+  "tarball": true,
+  "dir": true,
+  "deb": true,
+  "rpm": true,
+  "run": true,
+  "oci": true,
+}
+
 func init() {
   flag.Var(&qmlImports, "qmldir", "QML imports dir")
   flag.Var(&librariesDirs, "libs", "Additional libraries search paths")
+  flag.Var(&qtPluginsSpecs, "qt-plugins", "Qt plugins to include or exclude, e.g. sqldrivers=qsqlite or imageformats=-qwebp,-qtiff")
 }
 
 func main() {
@@ -82,6 +127,9 @@ func main() {
   currentExeFullPath = executablePath()
   log.Println("Current exe path is", currentExeFullPath)
 
+  config, err := loadProjectConfig(*configPathFlag)
+  if err != nil { log.Fatal(err) }
+
   appDirPath := resolveAppDir()
   os.RemoveAll(appDirPath)
   os.MkdirAll(appDirPath, os.ModePerm)
@@ -98,33 +146,66 @@ func main() {
     qtDeployer: &QtDeployer{
       qmakePath: resolveQMake(),
       qmakeVars: make(map[string]string),
-      deployedQmlImports: make(map[string]bool),
+      deployedQmlImports: newQmlImportNode(),
       qtEnv: make(map[QMakeKey]string),
       qmlImportDirs: qmlImports,
-      privateWidgetsDeployed: false,
       qtEnvironmentSet: false,
       translationsRequired: make(map[string]bool),
+      pluginRules: mergeQtPluginRules(defaultQtPluginRules(), config.QtPluginRules),
     },
 
     additionalLibPaths: make([]string, 0, 10),
     destinationRoot: appDirPath,
     targetExePath: resolveTargetExe(),
+    report: NewDeployReport(),
+    config: config,
+    runtimeRules: mergeRuntimeDependencyRules(builtinRuntimeDependencyRules, config.RuntimeDependencies),
   }
 
   for _, libpath := range librariesDirs {
     appDeployer.addAdditionalLibPath(libpath)
   }
 
-  appDeployer.DeployApp()
+  if err = appDeployer.DeployApp(); err != nil {
+    log.Fatal(err)
+  }
+
+  if err = appDeployer.createOutput(); err != nil {
+    log.Fatal(err)
+  }
 }
 
 func parseFlags() error {
   flag.Parse()
 
+  flag.Visit(func(f *flag.Flag) {
+    if f.Name == "out" { appImageRequested = generateAppImg() }
+  })
+
   _, err := os.Stat(*exePathFlag)
   if os.IsNotExist(err) { return err }
 
-  if len(*outTypeFlag) > 0 && (*outTypeFlag != "appimage") { return errors.New(appName + " only supports appimage type at this time") }
+  if _, ok := supportedOutputTypes[*outTypeFlag]; !ok { return fmt.Errorf("Unsupported output type %v", *outTypeFlag) }
+
+  if _, ok := appImageCompressions[*appImageCompressionFlag]; !ok && *outTypeFlag == "appimage" {
+    return fmt.Errorf("Unsupported AppImage compression %v", *appImageCompressionFlag)
+  }
+
+  if _, ok := tarballCompressions[*tarballCompressionFlag]; !ok {
+    return fmt.Errorf("Unsupported tarball compression %v", *tarballCompressionFlag)
+  }
+
+  if len(*maxSizeFlag) > 0 {
+    if maxAppDirSize, err = parseSize(*maxSizeFlag); err != nil { return err }
+  }
+
+  if qtPluginSelection, err = parsePluginSelection(qtPluginsSpecs); err != nil { return err }
+
+  imageFormats = make(map[string]bool)
+  for _, format := range strings.Split(*imageFormatsFlag, ",") {
+    format = strings.ToLower(strings.TrimSpace(format))
+    if len(format) > 0 { imageFormats[format] = true }
+  }
 
   appDirInfo, err := os.Stat(*appDirPathFlag)
   if err == nil && appDirInfo.IsDir() {
@@ -139,7 +220,7 @@ func parseFlags() error {
 func setupLogging() (f *os.File, err error) {
   f, err = os.OpenFile(*logPathFlag, os.O_RDWR | os.O_CREATE | os.O_APPEND, 0666)
   if err != nil {
-    fmt.Println("error opening file: %v", *logPathFlag)
+    fmt.Printf("error opening file: %v\n", *logPathFlag)
     return nil, err
   }
 
@@ -182,19 +263,21 @@ func resolveTargetExe() string {
   return foundPath
 }
 
+// tools which can print Qt environment in the order of preference
+var qmakeCandidates = []string { "qmake", "qmake6", "qmake-qt5", "qmake-qt4", "qtpaths6", "qtpaths" }
+
 func resolveQMake() string {
-  var err error
   currentPath := *qmakePathFlag
   if len(currentPath) == 0 { currentPath = "qmake" }
 
-  if _, err = os.Stat(currentPath); os.IsNotExist(err) {
-    if currentPath, err = exec.LookPath("qmake"); err != nil {
-      if currentPath, err = exec.LookPath("qmake-qt5"); err != nil {
-        if currentPath, err = exec.LookPath("qmake-qt4"); err != nil {
-          return ""
-        }
+  if _, err := os.Stat(currentPath); os.IsNotExist(err) {
+    for _, candidate := range qmakeCandidates {
+      if currentPath, err = exec.LookPath(candidate); err == nil {
+        return currentPath
       }
     }
+
+    return ""
   }
 
   return currentPath
@@ -203,3 +286,8 @@ func resolveQMake() string {
 func generateAppImg() bool {
   return *outTypeFlag == "appimage"
 }
+
+// AppRun is also handy as a launcher in the root of the tarball
+func generateAppRun() bool {
+  return generateAppImg() || (*outTypeFlag == "tarball")
+}
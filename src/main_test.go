@@ -2,7 +2,26 @@ package main
 
 import (
   "testing"
+  "flag"
   "bytes"
+  "os"
+  "io"
+  "strings"
+  "reflect"
+  "strconv"
+  "sort"
+  "sync"
+  "path/filepath"
+  "os/exec"
+  "fmt"
+  "crypto/md5"
+  "crypto/sha256"
+  "encoding/json"
+  "debug/elf"
+  "encoding/binary"
+  "io/ioutil"
+  "archive/tar"
+  "compress/gzip"
 )
 
 func TestBasicBytesReplace(t *testing.T) {
@@ -151,3 +170,1669 @@ func TestInSliceOfSlice(t *testing.T) {
     t.Fatalf("Expected %v but got %v", expectedResult, buffer)
   }
 }
+
+func TestCategorizeTarget(t *testing.T) {
+  cases := map[string]FileCategory {
+    "lib": CATEGORY_LIBRARY,
+    "plugins/platforms": CATEGORY_PLUGIN,
+    "qml/QtQuick/Controls": CATEGORY_QML,
+    "translations/qtwebengine_locales": CATEGORY_TRANSLATION,
+    "libexecs": CATEGORY_DATA,
+    ".": CATEGORY_DATA,
+  }
+
+  for target, expected := range cases {
+    if category := categorizeTarget(target); category != expected {
+      t.Errorf("Expected category %v for %v but got %v", expected, target, category)
+    }
+  }
+}
+
+func TestParseSize(t *testing.T) {
+  cases := map[string]int64 {
+    "1024": 1024,
+    "500K": 500 * 1024,
+    "200M": 200 * 1024 * 1024,
+    "1.5G": 3 * 512 * 1024 * 1024,
+    "10MiB": 10 * 1024 * 1024,
+  }
+
+  for value, expected := range cases {
+    size, err := parseSize(value)
+    if err != nil || size != expected {
+      t.Errorf("Expected %v for %v but got %v (%v)", expected, value, size, err)
+    }
+  }
+
+  if _, err := parseSize("abc"); err == nil {
+    t.Errorf("Expected error for invalid size")
+  }
+}
+
+func TestSizeCategory(t *testing.T) {
+  cases := map[string]string {
+    "lib/libQt5Core.so.5": "Qt libraries",
+    "lib/libpng16.so.16": "system libraries",
+    "plugins/sqldrivers/libqsqlite.so": "Qt plugins: sqldrivers",
+    "qml/QtQuick/Controls/Button.qml": "QML modules",
+    "resources/qtwebengine_resources.pak": "WebEngine resources",
+    "translations/qtwebengine_locales/de.pak": "WebEngine resources",
+    "translations/qt_de.qm": "translations",
+    "TestApp": "other",
+  }
+
+  for path, expected := range cases {
+    if category := sizeCategory(path); category != expected {
+      t.Errorf("Expected category %v for %v but got %v", expected, path, category)
+    }
+  }
+}
+
+func TestElfFileSizeOfTestBinary(t *testing.T) {
+  contents, err := ioutil.ReadFile(os.Args[0])
+  if err != nil { t.Fatal(err) }
+
+  f, err := elf.NewFile(bytes.NewReader(contents))
+  if err != nil { t.Fatal(err) }
+
+  size, err := elfFileSize(contents, f)
+  if err != nil { t.Fatal(err) }
+
+  if size != int64(len(contents)) {
+    t.Fatalf("Expected ELF size %v but got %v", len(contents), size)
+  }
+}
+
+func TestSanitizePackageName(t *testing.T) {
+  cases := map[string]string {
+    "TestApp": "testapp",
+    "my_app 2": "my-app-2",
+    "qt5-app+extra.bin": "qt5-app+extra.bin",
+  }
+
+  for name, expected := range cases {
+    if sanitized := sanitizePackageName(name); sanitized != expected {
+      t.Errorf("Expected %v for %v but got %v", expected, name, sanitized)
+    }
+  }
+}
+
+func TestDebDescription(t *testing.T) {
+  description := debDescription("Synopsis\nFirst line\n\nSecond paragraph\n")
+  expected := "Synopsis\n First line\n .\n Second paragraph"
+
+  if description != expected {
+    t.Fatalf("Expected %q but got %q", expected, description)
+  }
+}
+
+func TestRpmHeaderRegion(t *testing.T) {
+  h := &rpmHeader{}
+  h.addInt32(RPMTAG_SIZE, 42)
+  h.addString(RPMTAG_NAME, "test")
+
+  data := h.bytes(RPMTAG_HEADERIMMUTABLE)
+  indexCount := binary.BigEndian.Uint32(data[8:12])
+  storeSize := binary.BigEndian.Uint32(data[12:16])
+
+  if indexCount != 3 {
+    t.Fatalf("Expected 3 index entries but got %v", indexCount)
+  }
+
+  if int(16 + indexCount * 16 + storeSize) != len(data) {
+    t.Fatalf("Unexpected header size %v", len(data))
+  }
+
+  store := data[16 + indexCount * 16:]
+  trailerOffset := binary.BigEndian.Uint32(data[24:28])
+  trailerTag := binary.BigEndian.Uint32(store[trailerOffset:])
+  trailerIndexOffset := int32(binary.BigEndian.Uint32(store[trailerOffset + 8:]))
+
+  if trailerTag != RPMTAG_HEADERIMMUTABLE || trailerIndexOffset != -3 * 16 {
+    t.Fatalf("Wrong region trailer: tag %v offset %v", trailerTag, trailerIndexOffset)
+  }
+
+  // entries are sorted by tag: name goes before size
+  if firstTag := binary.BigEndian.Uint32(data[32:36]); firstTag != RPMTAG_NAME {
+    t.Fatalf("Expected first tag %v but got %v", RPMTAG_NAME, firstTag)
+  }
+}
+
+func TestRpmHeaderLongSize(t *testing.T) {
+  h := &rpmHeader{}
+  h.addSize(RPMTAG_SIZE, RPMTAG_LONGSIZE, rpmMaxFileSize)
+  h.addSize(RPMSIGTAG_SIZE, RPMSIGTAG_LONGSIZE, rpmMaxFileSize + 1)
+
+  if entry := h.entries[0]; entry.tag != RPMTAG_SIZE || entry.tagType != RPM_INT32_TYPE {
+    t.Errorf("Expected 32-bit size but got %v", entry)
+  }
+
+  if entry := h.entries[1]; entry.tag != RPMSIGTAG_LONGSIZE || entry.tagType != RPM_INT64_TYPE || binary.BigEndian.Uint64(entry.data) != 1 << 32 {
+    t.Errorf("Expected 64-bit size but got %v", entry)
+  }
+}
+
+func TestEscapeShellValue(t *testing.T) {
+  tests := map[string]string {
+    "$APPDIR/data": "$APPDIR/data",
+    `say "hi"`: `say \"hi\"`,
+    "a`b`": "a\\`b\\`",
+    `c:\dir`: `c:\\dir`,
+  }
+
+  for value, expected := range tests {
+    if escaped := escapeShellValue(value); escaped != expected {
+      t.Errorf("Expected %v but got %v for %v", expected, escaped, value)
+    }
+  }
+}
+
+func TestWriteQtConf(t *testing.T) {
+  dir, err := ioutil.TempDir("", "qtconf")
+  if err != nil { t.Fatal(err) }
+  defer os.RemoveAll(dir)
+
+  if err = writeQtConf(dir, ".."); err != nil { t.Fatal(err) }
+
+  contents, err := ioutil.ReadFile(dir + "/qt.conf")
+  if err != nil { t.Fatal(err) }
+
+  for _, line := range []string{ "[Paths]\n", "Prefix = ..\n", "Plugins = plugins\n", "LibraryExecutables = libexecs\n" } {
+    if !bytes.Contains(contents, []byte(line)) {
+      t.Errorf("qt.conf does not contain %q:\n%s", line, contents)
+    }
+  }
+}
+
+func TestReplaceWholeString(t *testing.T) {
+  buffer := []byte("\x00lib/qt/plugins\x00lib/qt\x00")
+  expectedResult := []byte("\x00lib/qt/plugins\x00\x00\x00\x00\x00\x00\x00\x00")
+
+  offset, previous, err := replaceString(buffer, "lib/qt", "")
+  if err != nil { t.Fatal(err) }
+
+  if offset != 16 || previous != "lib/qt" {
+    t.Errorf("Unexpected offset %v or previous value %v", offset, previous)
+  }
+
+  if bytes.Compare(buffer, expectedResult) != 0 {
+    t.Fatalf("Expected %v but got %v", expectedResult, buffer)
+  }
+}
+
+func TestQtCorePatchesWithRelativePaths(t *testing.T) {
+  // prefix-only layout with paths relative to it like on Arch Linux
+  contents := []byte("qt_prfxpath=/usr\x00doc\x00lib/qt/plugins\x00lib/qt/qml\x00")
+
+  patches := applyQtCorePatches(contents)
+  if failed := verifyQtCorePatches(contents, patches); len(failed) > 0 {
+    t.Fatalf("Failed to verify %v", failed)
+  }
+
+  if missing := missingQtCoreGroups(patches); len(missing) > 0 {
+    t.Fatalf("Critical paths are missing: %v", missing)
+  }
+
+  if value := readCString(contents, bytes.Index(contents, []byte("plugins"))); value != "plugins" {
+    t.Errorf("Unexpected plugins path %v", value)
+  }
+}
+
+func TestQtCorePatchesMissingPlugins(t *testing.T) {
+  contents := []byte("qt_prfxpath=/usr\x00qt_qml2path=/usr/lib/qml\x00")
+
+  patches := applyQtCorePatches(contents)
+  verifyQtCorePatches(contents, patches)
+
+  missing := missingQtCoreGroups(patches)
+  if len(missing) != 1 || missing[0] != QTCORE_PLUGINS_GROUP {
+    t.Fatalf("Expected missing plugins but got %v", missing)
+  }
+}
+
+func TestQtModuleName(t *testing.T) {
+  cases := map[string]string {
+    "libqt5gui.so.5": "gui",
+    "libQt6Network.so.6": "network",
+    "libqt53dcore.so.5": "3dcore",
+    "libqtav.so.1": "av",
+  }
+
+  for libname, expected := range cases {
+    if module := qtModuleName(libname); module != expected {
+      t.Errorf("Expected %v but got %v for %v", expected, module, libname)
+    }
+  }
+}
+
+func TestParseQMakeOutput(t *testing.T) {
+  output := "QT_INSTALL_PREFIX:/opt/qt\n" +
+    "QT_INSTALL_PREFIX/raw:/opt/qt\n" +
+    "QT_INSTALL_PLUGINS:/opt/qt/plugins\n" +
+    "QT_INSTALL_PLUGINS/get:/home/user/Qt 5.9:relocated/plugins\n" +
+    "QT_INSTALL_EXAMPLES/src:/src/qt/examples\n" +
+    "QT_VERSION:5.9.1\n"
+
+  qd := &QtDeployer{ qmakeVars: parseQMakeOutput(output), qtEnv: make(map[QMakeKey]string) }
+  qd.parseQtVars()
+
+  expected := map[QMakeKey]string {
+    QT_INSTALL_PREFIX: "/opt/qt",
+    QT_INSTALL_PLUGINS: "/home/user/Qt 5.9:relocated/plugins",
+    QT_INSTALL_EXAMPLES: "/src/qt/examples",
+    QT_INSTALL_TESTS: "",
+    QT_VERSION: "5.9.1",
+  }
+
+  for key, value := range expected {
+    if qd.qtEnv[key] != value {
+      t.Errorf("Expected %v but got %v for %v", value, qd.qtEnv[key], qmakeKeyNames[key])
+    }
+  }
+}
+
+func TestQtVersionsMatch(t *testing.T) {
+  if version := qtLibraryVersion("/opt/Qt/5.9.1/gcc_64/lib/libQt5Core.so.5.9.1"); version != "5.9.1" {
+    t.Fatalf("Unexpected library version %v", version)
+  }
+
+  cases := []struct { qmake, library string; match bool } {
+    { "5.9.1", "5.9.1", true },
+    { "5.9.1", "5", true },
+    { "5.12.8", "5.9.1", false },
+    { "6.5.2", "5", false },
+  }
+
+  for _, c := range cases {
+    if match := qtVersionsMatch(c.qmake, c.library); match != c.match {
+      t.Errorf("Expected %v for %v and %v", c.match, c.qmake, c.library)
+    }
+  }
+}
+
+func TestFindQMakeForQtCore(t *testing.T) {
+  prefix, err := ioutil.TempDir("", "qtprefix")
+  if err != nil { t.Fatal(err) }
+  defer os.RemoveAll(prefix)
+
+  os.MkdirAll(prefix + "/lib", os.ModePerm)
+  os.MkdirAll(prefix + "/bin", os.ModePerm)
+  ioutil.WriteFile(prefix + "/lib/libQt5Core.so.5.9.1", []byte("\x00qt_prfxpath=/nonexistent\x00"), 0644)
+  ioutil.WriteFile(prefix + "/bin/qmake", []byte{}, 0755)
+
+  if qmakePath := findQMakeForQtCore(prefix + "/lib/libQt5Core.so.5.9.1"); qmakePath != prefix + "/bin/qmake" {
+    t.Fatalf("Unexpected qmake %v", qmakePath)
+  }
+
+  if qtPrefix, err := readQtCorePrefix(prefix + "/lib/libQt5Core.so.5.9.1"); err != nil || qtPrefix != "/nonexistent" {
+    t.Fatalf("Unexpected prefix %v (%v)", qtPrefix, err)
+  }
+}
+
+func TestMergeQtPluginRules(t *testing.T) {
+  builtin := []QtPluginRule {
+    { Module: "gui", PluginDirs: []string{ "imageformats" } },
+    { Module: "sql", PluginDirs: []string{ "sqldrivers" } },
+  }
+
+  custom := []QtPluginRule {
+    { Module: "gui", PluginDirs: []string{ "platformthemes" }, Extend: true },
+    { Module: "sql" },
+    { Module: "mymodule", Qt: 5, PluginDirs: []string{ "myplugins" } },
+  }
+
+  rules := mergeQtPluginRules(builtin, custom)
+
+  if len(rules) != 3 {
+    t.Fatalf("Expected 3 rules but got %v", len(rules))
+  }
+
+  if len(rules[0].PluginDirs) != 2 || len(builtin[0].PluginDirs) != 1 {
+    t.Errorf("Rule was not extended correctly: %v", rules[0].PluginDirs)
+  }
+
+  if len(rules[1].PluginDirs) != 0 {
+    t.Errorf("Rule was not overridden: %v", rules[1].PluginDirs)
+  }
+
+  if !rules[2].matches("mymodule", 5) || rules[2].matches("mymodule", 6) {
+    t.Errorf("Unexpected matching of the added rule")
+  }
+}
+
+func TestMergeQtPluginRulesForAllVersions(t *testing.T) {
+  builtin := []QtPluginRule {
+    { Module: "quick", Qt: 5, PluginDirs: []string{ "scenegraph" } },
+    { Module: "quick", Qt: 6, PluginDirs: []string{ "qmltooling" } },
+    { Module: "sql", Qt: 5, PluginDirs: []string{ "sqldrivers" } },
+    { Module: "sql", Qt: 6, PluginDirs: []string{ "sqldrivers" } },
+  }
+
+  custom := []QtPluginRule {
+    { Module: "quick", PluginDirs: []string{ "myquick" } },
+    { Module: "sql", PluginDirs: []string{ "mysql" }, Extend: true },
+  }
+
+  rules := mergeQtPluginRules(builtin, custom)
+
+  described := make([]string, 0, len(rules))
+  for _, rule := range rules {
+    described = append(described, fmt.Sprintf("%v %v %v", rule.Module, rule.Qt, rule.PluginDirs))
+  }
+
+  expected := []string{ "quick 0 [myquick]", "sql 5 [sqldrivers mysql]", "sql 6 [sqldrivers mysql]" }
+  if !reflect.DeepEqual(described, expected) {
+    t.Errorf("Expected %v but got %v", expected, described)
+  }
+}
+
+func TestBuiltinQtPluginRulesCoverTranslationModules(t *testing.T) {
+  translationMaps := map[int]map[string]string {
+    5: moduleToTranslationMap,
+    6: qt6ModuleToTranslationMap,
+  }
+
+  for majorVersion, translationMap := range translationMaps {
+    for module := range translationMap {
+      name := qtModuleName("lib" + module + ".so")
+      found := false
+      for _, rule := range builtinQtPluginRules {
+        if rule.matches(name, majorVersion) { found = true }
+      }
+
+      if !found {
+        t.Errorf("No plugin rule for Qt %v module %v", majorVersion, module)
+      }
+    }
+  }
+}
+
+func TestExpandPluginPattern(t *testing.T) {
+  pluginsPath, err := ioutil.TempDir("", "plugins")
+  if err != nil { t.Fatal(err) }
+  defer os.RemoveAll(pluginsPath)
+
+  os.MkdirAll(pluginsPath + "/platforms", os.ModePerm)
+  for _, name := range []string{ "libqxcb.so", "libqwayland-egl.so", "libqwayland-generic.so" } {
+    ioutil.WriteFile(pluginsPath + "/platforms/" + name, []byte{}, 0644)
+  }
+
+  qd := &QtDeployer{ qtEnv: map[QMakeKey]string{ QT_INSTALL_PLUGINS: pluginsPath } }
+
+  plugins := qd.expandPluginPattern("platforms/libqwayland-*.so")
+  if len(plugins) != 2 || plugins[0] != "platforms/libqwayland-egl.so" {
+    t.Fatalf("Unexpected plugins %v", plugins)
+  }
+
+  if plugins = qd.expandPluginPattern("platforms/libqxcb.so"); len(plugins) != 1 {
+    t.Fatalf("Unexpected plugins %v", plugins)
+  }
+}
+
+func TestPluginSelection(t *testing.T) {
+  selection, err := parsePluginSelection([]string{ "sqldrivers=qsqlite", "imageformats=-qwebp,-libqtiff.so;styles=" })
+  if err != nil { t.Fatal(err) }
+
+  cases := map[string]bool {
+    "sqldrivers/libqsqlite.so": true,
+    "sqldrivers/libqsqlmysql.so": false,
+    "imageformats/libqjpeg.so": true,
+    "imageformats/libqwebp.so": false,
+    "imageformats/libqtiff.so": false,
+    "styles/libqgtk3style.so": true,
+    "platforms/libqxcb.so": true,
+  }
+
+  for path, expected := range cases {
+    if selected := selection.isSelected(path); selected != expected {
+      t.Errorf("Expected %v for %v", expected, path)
+    }
+  }
+
+  if _, err = parsePluginSelection([]string{ "sqldrivers" }); err == nil {
+    t.Errorf("Expected error for selection without plugins")
+  }
+}
+
+func TestParseQtCborMetadata(t *testing.T) {
+  data := []byte("QTMETADATA !\x00\x05\x0f\x00\xbf\x02\x65IIDxx\x03\x6bQJpegPlugin\x04\xbf\x64Keys\x9f\x63jpg\x64jpeg\xff\xff\xff")
+
+  metadata, err := parseQtMetadataSection(data)
+  if err != nil { t.Fatal(err) }
+
+  if metadata.IID != "IIDxx" || metadata.ClassName != "QJpegPlugin" || metadata.QtVersion != "5.15" {
+    t.Errorf("Unexpected metadata %v", metadata)
+  }
+
+  if len(metadata.Keys) != 2 || metadata.Keys[1] != "jpeg" {
+    t.Errorf("Unexpected keys %v", metadata.Keys)
+  }
+}
+
+// builds binary JSON object or array with values of type {kind, int value or payload}
+func binaryJsonBase(isObject bool, keys []string, kinds []uint32, ints []int, payloads [][]byte) []byte {
+  buffer := make([]byte, 12)
+  table := make([]uint32, 0, len(kinds))
+
+  for i, kind := range kinds {
+    entryOffset := len(buffer)
+    if isObject {
+      key := make([]byte, 2 + len(keys[i]))
+      binary.LittleEndian.PutUint16(key, uint16(len(keys[i])))
+      copy(key[2:], keys[i])
+      for len(key) % 4 != 0 { key = append(key, 0) }
+      buffer = append(buffer, make([]byte, 4)...)
+      buffer = append(buffer, key...)
+    }
+
+    bits := kind
+    if isObject { bits |= 1 << 4 }
+    if payloads[i] != nil {
+      bits |= uint32(len(buffer)) << 5
+      if kind == 3 { bits |= 1 << 3 }
+      buffer = append(buffer, payloads[i]...)
+    } else {
+      bits |= 1 << 3 | uint32(ints[i]) << 5
+    }
+
+    if isObject {
+      binary.LittleEndian.PutUint32(buffer[entryOffset:], bits)
+      table = append(table, uint32(entryOffset))
+    } else {
+      table = append(table, bits)
+    }
+  }
+
+  tableOffset := len(buffer)
+  for _, entry := range table {
+    buffer = append(buffer, 0, 0, 0, 0)
+    binary.LittleEndian.PutUint32(buffer[len(buffer) - 4:], entry)
+  }
+
+  var objectBit uint32
+  if isObject { objectBit = 1 }
+  binary.LittleEndian.PutUint32(buffer[0:], uint32(len(buffer)))
+  binary.LittleEndian.PutUint32(buffer[4:], uint32(len(kinds)) << 1 | objectBit)
+  binary.LittleEndian.PutUint32(buffer[8:], uint32(tableOffset))
+  return buffer
+}
+
+func TestParseQtBinaryJsonMetadata(t *testing.T) {
+  latin1 := func(s string) []byte {
+    b := append([]byte{ byte(len(s)), 0 }, s...)
+    for len(b) % 4 != 0 { b = append(b, 0) }
+    return b
+  }
+
+  keys := binaryJsonBase(false, nil, []uint32{ 3, 3 }, []int{ 0, 0 }, [][]byte{ latin1("gif"), latin1("GIF") })
+  pluginData := binaryJsonBase(true, []string{ "Keys" }, []uint32{ 4 }, []int{ 0 }, [][]byte{ keys })
+  root := binaryJsonBase(true,
+    []string{ "IID", "version", "MetaData" },
+    []uint32{ 3, 2, 5 },
+    []int{ 0, 0x050902, 0 },
+    [][]byte{ latin1("org.qt-project.Qt.QImageIOHandlerFactoryInterface"), nil, pluginData })
+
+  data := append([]byte("QTMETADATA  qbjs\x01\x00\x00\x00"), root...)
+
+  metadata, err := parseQtMetadataSection(data)
+  if err != nil { t.Fatal(err) }
+
+  if metadata.IID != "org.qt-project.Qt.QImageIOHandlerFactoryInterface" || metadata.QtVersion != "5.9" {
+    t.Errorf("Unexpected metadata %v", metadata)
+  }
+
+  if len(metadata.Keys) != 2 || metadata.Keys[0] != "gif" {
+    t.Errorf("Unexpected keys %v", metadata.Keys)
+  }
+}
+
+func TestQtPluginVersionMismatch(t *testing.T) {
+  tests := []struct {
+    plugin, qt string
+    mismatch bool
+  }{
+    { "5.9", "5.9.1", false },
+    { "5.6", "5.9.1", false },
+    { "5.12", "5.9.1", true },
+    { "6.2", "5.15.2", true },
+    { "", "5.9.1", false },
+  }
+
+  for _, test := range tests {
+    if qtPluginVersionMismatch(test.plugin, test.qt) != test.mismatch {
+      t.Errorf("Unexpected mismatch result for plugin %v and Qt %v", test.plugin, test.qt)
+    }
+  }
+}
+
+func TestOpenSslSonameVersions(t *testing.T) {
+  tests := []struct {
+    contents string
+    versions []string
+  }{
+    { "\x00OpenSSL 1.1.1k  25 Mar 2021\x00", []string{ "1.1" } },
+    { "\x00OpenSSL 1.0.2g  1 Mar 2016\x00", []string{ "1.0.2", "1.0.0", "10" } },
+    { "\x00OpenSSL 3.0.2 15 Mar 2022\x00", []string{ "3" } },
+    { "\x00no version here\x00", nil },
+  }
+
+  for _, test := range tests {
+    versions := openSslSonameVersions(findOpenSslVersion([]byte(test.contents)))
+    if !reflect.DeepEqual(versions, test.versions) {
+      t.Errorf("Unexpected versions %v for %q", versions, test.contents)
+    }
+  }
+}
+
+func TestParseLdconfigOutput(t *testing.T) {
+  output := "1234 libs found in cache `/etc/ld.so.cache'\n" +
+    "\tlibssl.so.1.1 (libc6,x86-64) => /usr/lib/x86_64-linux-gnu/libssl.so.1.1\n" +
+    "\tlibssl.so.1.1 (libc6) => /usr/lib/i386-linux-gnu/libssl.so.1.1\n" +
+    "\tlibcrypto.so.1.1 (libc6,x86-64) => /usr/lib/x86_64-linux-gnu/libcrypto.so.1.1\n"
+
+  cache := parseLdconfigOutput(output)
+  if len(cache["libssl.so.1.1"]) != 2 || cache["libssl.so.1.1"][0] != "/usr/lib/x86_64-linux-gnu/libssl.so.1.1" {
+    t.Errorf("Unexpected libssl entries %v", cache["libssl.so.1.1"])
+  }
+
+  if len(cache) != 2 {
+    t.Errorf("Unexpected cache %v", cache)
+  }
+}
+
+func TestMergeRuntimeDependencyRules(t *testing.T) {
+  custom := []RuntimeDependencyRule{
+    { Soname: "libgio-2.0.so", Dirs: []string{ "gio/modules" } },
+    { Soname: "libfoo.so", Libraries: []string{ "libfoo-backend.so" } },
+  }
+
+  rules := mergeRuntimeDependencyRules(builtinRuntimeDependencyRules, custom)
+  if len(rules) != len(builtinRuntimeDependencyRules) + 1 {
+    t.Fatalf("Unexpected rules count %v", len(rules))
+  }
+
+  for _, rule := range rules {
+    if rule.matches("libgio-2.0.so.0") && (rule.Host || len(rule.Dirs) != 1) {
+      t.Errorf("Built-in rule is not replaced: %v", rule)
+    }
+  }
+
+  if !rules[len(rules) - 1].matches("libfoo.so.1") {
+    t.Errorf("Custom rule is not added")
+  }
+
+  if builtinRuntimeDependencyRules[len(builtinRuntimeDependencyRules) - 1].Dirs != nil {
+    t.Errorf("Built-in rules are modified")
+  }
+}
+
+func TestParseLdDebugOutput(t *testing.T) {
+  output := "     12345:\tfind library=libfoo.so.1 [0]; searching\n" +
+    "     12345:\t  trying file=/tmp/AppDir/lib/libfoo.so.1\n" +
+    "     12345:\t\n" +
+    "     12345:\tfile=libfoo.so.1 [0];  generating link map\n" +
+    "     12345:\tcalling init: /lib64/ld-linux-x86-64.so.2\n" +
+    "     12345:\tcalling init: /tmp/AppDir/lib/libfoo.so.1\n" +
+    "     12345:\tcalling init: /usr/lib/libbar.so.2\n" +
+    "some output of the app\n" +
+    "     12346:\tcalling init: /usr/lib/libbar.so.2\n" +
+    "     12345:\tcalling init: /tmp/AppDir2/lib/libbaz.so\n"
+
+  libraries := parseLdDebugOutput([]byte(output), "/tmp/AppDir")
+  expected := []string{ "/usr/lib/libbar.so.2", "/tmp/AppDir2/lib/libbaz.so" }
+
+  if !reflect.DeepEqual(libraries, expected) {
+    t.Errorf("Expected %v but got %v", expected, libraries)
+  }
+}
+
+func TestParseLdDebugOutputWithoutInit(t *testing.T) {
+  // libnoinit has no init function, libdata is opened by path with dlopen()
+  output := "     12345:\tfind library=libnoinit.so.1 [0]; searching\n" +
+    "     12345:\t search path=/opt/lib:/usr/lib\t\t(LD_LIBRARY_PATH)\n" +
+    "     12345:\t  trying file=/opt/lib/libnoinit.so.1\n" +
+    "     12346:\t  trying file=/usr/lib/libother.so.3\n" +
+    "     12345:\t  trying file=/usr/lib/libnoinit.so.1\n" +
+    "     12345:\t\n" +
+    "     12345:\tfile=libnoinit.so.1 [0];  generating link map\n" +
+    "     12345:\t  dynamic: 0x00007f0000001000  base: 0x00007f0000000000   size: 0x0000000000002000\n" +
+    "     12345:\tfile=/usr/lib/data/libdata.so [0];  generating link map\n" +
+    "     12345:\tcalling init: /usr/lib/libnoinit.so.1\n"
+
+  libraries := parseLdDebugOutput([]byte(output), "/tmp/AppDir")
+  expected := []string{ "/usr/lib/libnoinit.so.1", "/usr/lib/data/libdata.so" }
+
+  if !reflect.DeepEqual(libraries, expected) {
+    t.Errorf("Expected %v but got %v", expected, libraries)
+  }
+}
+
+func TestScanQmlImports(t *testing.T) {
+  root, err := ioutil.TempDir("", "qmlscan")
+  if err != nil { t.Fatal(err) }
+  defer os.RemoveAll(root)
+
+  for _, module := range []string{ "QtQuick.2", "QtQuick/Controls.2", "QtQuick/Layouts", "QtQml/Models.2" } {
+    os.MkdirAll(root + "/qml/" + module, os.ModePerm)
+    ioutil.WriteFile(root + "/qml/" + module + "/qmldir", []byte{}, 0644)
+  }
+
+  os.MkdirAll(root + "/app", os.ModePerm)
+  ioutil.WriteFile(root + "/app/main.qml", []byte("/* header\n" +
+    "   import Fake 1.0 */\n" +
+    "import QtQuick 2.12; import QtQuick.Controls 2.5 as C // comment\n" +
+    "import QtQuick.Layouts\n" +
+    "import \"components\"\n" +
+    "import Missing.Module 1.0\n" +
+    "Item {\n" +
+    "  property string s: \"import Bogus 1.0\"\n" +
+    "}\n"), 0644)
+  ioutil.WriteFile(root + "/app/db.js", []byte(".pragma library\n.import QtQml.Models 2.1 as M\n"), 0644)
+
+  imports := scanQmlImports([]string{ root + "/app" }, []string{ root + "/qml" })
+
+  expected := map[string]string{
+    "QtQuick": root + "/qml/QtQuick.2",
+    "QtQuick.Controls": root + "/qml/QtQuick/Controls.2",
+    "QtQuick.Layouts": root + "/qml/QtQuick/Layouts",
+    "QtQml.Models": root + "/qml/QtQml/Models.2",
+  }
+
+  if len(imports) != len(expected) {
+    t.Fatalf("Unexpected imports %v", imports)
+  }
+
+  for _, qmlImport := range imports {
+    if expected[qmlImport.Name] != qmlImport.Path || qmlImport.ImportType != "module" {
+      t.Errorf("Unexpected import %v", qmlImport)
+    }
+  }
+}
+
+func TestParseQmldir(t *testing.T) {
+  dir, err := ioutil.TempDir("", "qmldir")
+  if err != nil { t.Fatal(err) }
+  defer os.RemoveAll(dir)
+
+  ioutil.WriteFile(dir + "/qmldir", []byte("module QtQuick.Controls\n" +
+    "# comment\n" +
+    "plugin qtquickcontrols2plugin\n" +
+    "optional plugin qtquickcontrols2implplugin impl\n" +
+    "classname QtQuickControls2Plugin\n" +
+    "typeinfo plugins.qmltypes\n" +
+    "designersupported\n" +
+    "depends QtQuick 2.15\n" +
+    "import QtQuick.Controls.impl auto\n" +
+    "Button 2.0 Button.qml\n"), 0644)
+
+  module, err := parseQmldir(dir + "/qmldir")
+  if err != nil { t.Fatal(err) }
+
+  if module.name != "QtQuick.Controls" || !module.designerSupported {
+    t.Errorf("Unexpected module %v", module)
+  }
+
+  if !reflect.DeepEqual(module.plugins, []string{ "libqtquickcontrols2plugin.so", "impl/libqtquickcontrols2implplugin.so" }) {
+    t.Errorf("Unexpected plugins %v", module.plugins)
+  }
+
+  if !reflect.DeepEqual(module.typeInfo, []string{ "plugins.qmltypes" }) {
+    t.Errorf("Unexpected type info %v", module.typeInfo)
+  }
+
+  if !reflect.DeepEqual(module.depends, []qmlImportStatement{ { name: "QtQuick", version: "2.15" } }) ||
+    !reflect.DeepEqual(module.imports, []qmlImportStatement{ { name: "QtQuick.Controls.impl" } }) {
+    t.Errorf("Unexpected dependencies %v and %v", module.depends, module.imports)
+  }
+}
+
+func TestDeployedQmlImportsTree(t *testing.T) {
+  qd := &QtDeployer{ deployedQmlImports: newQmlImportNode() }
+  qd.accountQmlImport("/usr/lib/qt5/qml/QtQuick", "QtQuick", false)
+  qd.accountQmlImport("/usr/lib/qt5/qml/QtGraphicalEffects/", "QtGraphicalEffects", true)
+
+  // module deployed by its qmldir does not include nested modules
+  if _, _, deployed := qd.findDeployedQmlImport("/usr/lib/qt5/qml/QtQuick/Controls.2"); deployed {
+    t.Errorf("Nested import is covered by the module deployed by qmldir")
+  }
+
+  if path, _, deployed := qd.findDeployedQmlImport("/usr/lib/qt5/qml/QtQuick"); !deployed || path != "/usr/lib/qt5/qml/QtQuick" {
+    t.Errorf("Module is not deployed: %v %v", path, deployed)
+  }
+
+  // recursively deployed module includes everything below it
+  parentPath, parentName, deployed := qd.findDeployedQmlImport("/usr/lib/qt5/qml/QtGraphicalEffects/private")
+  if !deployed || parentPath != "/usr/lib/qt5/qml/QtGraphicalEffects" || parentName != "QtGraphicalEffects" {
+    t.Errorf("Nested import is not covered: %v %v %v", parentPath, parentName, deployed)
+  }
+
+  // nested import deployed on its own is still recognized under deployed parent
+  qd.accountQmlImport("/usr/lib/qt5/qml/QtQuick/Layouts", "QtQuick.Layouts", false)
+  if path, _, deployed := qd.findDeployedQmlImport("/usr/lib/qt5/qml/QtQuick/Layouts"); !deployed || path != "/usr/lib/qt5/qml/QtQuick/Layouts" {
+    t.Errorf("Nested import is not deployed: %v %v", path, deployed)
+  }
+
+  for _, path := range []string{ "/usr/lib/qt5/qml/QtQuick.2", "/usr/lib/qt5/qml", "/usr/lib/qt5/qml/QtQml" } {
+    if _, _, deployed := qd.findDeployedQmlImport(path); deployed {
+      t.Errorf("Import %v should not be deployed", path)
+    }
+  }
+}
+
+func TestDeployQmlModule(t *testing.T) {
+  tests := []struct {
+    module string
+    files map[string]string
+    libs []string
+    copied []string
+    imports []string
+  }{
+    {
+      module: "Mod",
+      files: map[string]string {
+        "Mod/qmldir": "module Mod\nplugin modplugin\noptional plugin modextra\ndepends Dep 1.0\n",
+        "Mod/libmodplugin.so": "",
+        "Mod/libmodplugin_debug.so": "",
+        "Mod/Main.qml": "import Other 1.0\nItem {}\n",
+        "Mod/impl/Helper.qml": "import Helper\nItem {}\n",
+        "Mod/impl/libunlisted.so": "",
+        "Mod/Nested/qmldir": "module Mod.Nested\nplugin nestedplugin\ndepends NestedDep\n",
+        "Mod/Nested/libnestedplugin.so": "",
+        "Mod/Nested/Nested.qml": "import NestedImport\nItem {}\n",
+      },
+      libs: []string{ "Mod/libmodplugin.so" },
+      copied: []string{ "Mod/Main.qml", "Mod/impl/Helper.qml", "Mod/qmldir" },
+      imports: []string{ "Dep", "Helper", "Other" },
+    },
+    {
+      // Qt 5 desktop style of Controls needs PrivateWidgets which qmldir does not list
+      module: "QtQuick/Controls",
+      files: map[string]string {
+        "QtQuick/Controls/qmldir": "module QtQuick.Controls\nplugin qtquickcontrolsplugin\n",
+        "QtQuick/Controls/libqtquickcontrolsplugin.so": "",
+        "QtQuick/Controls/Styles/Desktop/ButtonStyle.qml": "import QtQuick 2.2\nimport QtQuick.Controls 1.2\nimport QtQuick.PrivateWidgets 1.1\nStyleItem {}\n",
+        "QtQuick/Controls/Private/qmldir": "module QtQuick.Controls.Private\n",
+        "QtQuick/PrivateWidgets/qmldir": "module QtQuick.PrivateWidgets\nplugin widgetsplugin\n",
+        "QtQuick/PrivateWidgets/libwidgetsplugin.so": "",
+      },
+      libs: []string{ "QtQuick/Controls/libqtquickcontrolsplugin.so" },
+      copied: []string{ "QtQuick/Controls/Styles/Desktop/ButtonStyle.qml", "QtQuick/Controls/qmldir" },
+      imports: []string{ "QtQuick", "QtQuick.Controls", "QtQuick.PrivateWidgets" },
+    },
+  }
+
+  for _, test := range tests {
+    qmlRoot, err := ioutil.TempDir("", "qml")
+    if err != nil { t.Fatal(err) }
+    defer os.RemoveAll(qmlRoot)
+
+    writeTestFiles(t, qmlRoot, test.files)
+    ad, recorder := newRecordingDeployer()
+
+    module, err := parseQmldir(filepath.Join(qmlRoot, test.module, "qmldir"))
+    if err != nil { t.Fatal(err) }
+
+    imports := ad.deployQmlModule(qmlRoot, test.module, module)
+    libs, copied := recorder.finish(ad)
+
+    if !reflect.DeepEqual(libs, test.libs) {
+      t.Errorf("Unexpected libraries %v of %v", libs, test.module)
+    }
+
+    if !reflect.DeepEqual(copied, test.copied) {
+      t.Errorf("Unexpected copied files %v of %v", copied, test.module)
+    }
+
+    names := make([]string, 0, len(imports))
+    for _, statement := range imports {
+      names = append(names, statement.name)
+    }
+    sort.Strings(names)
+
+    if !reflect.DeepEqual(names, test.imports) {
+      t.Errorf("Unexpected imports %v of %v", names, test.module)
+    }
+  }
+}
+
+func writeTestFiles(t *testing.T, root string, files map[string]string) {
+  for name, contents := range files {
+    path := filepath.Join(root, name)
+    if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil { t.Fatal(err) }
+    if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil { t.Fatal(err) }
+  }
+}
+
+// records lib and copy tasks instead of processing them
+type taskRecorder struct {
+  mutex sync.Mutex
+  libs []string
+  copies []string
+}
+
+func newRecordingDeployer() (*AppDeployer, *taskRecorder) {
+  ad := &AppDeployer{
+    libsChannel: make(chan *DeployRequest),
+    copyChannel: make(chan *DeployRequest),
+    report: NewDeployReport(),
+  }
+
+  recorder := &taskRecorder{}
+  go recorder.record(ad, ad.libsChannel, &recorder.libs)
+  go recorder.record(ad, ad.copyChannel, &recorder.copies)
+
+  return ad, recorder
+}
+
+func (tr *taskRecorder) record(ad *AppDeployer, channel chan *DeployRequest, paths *[]string) {
+  for request := range channel {
+    tr.mutex.Lock()
+    *paths = append(*paths, request.sourcePath)
+    tr.mutex.Unlock()
+    ad.waitGroup.Done()
+  }
+}
+
+// waits for queued tasks and returns sorted source paths of libs and copied files
+func (tr *taskRecorder) finish(ad *AppDeployer) ([]string, []string) {
+  ad.waitGroup.Wait()
+  close(ad.libsChannel)
+  close(ad.copyChannel)
+
+  tr.mutex.Lock()
+  defer tr.mutex.Unlock()
+  sort.Strings(tr.libs)
+  sort.Strings(tr.copies)
+  return tr.libs, tr.copies
+}
+
+func TestProcessQmlImportsFromImportPaths(t *testing.T) {
+  root, err := ioutil.TempDir("", "qmlimports")
+  if err != nil { t.Fatal(err) }
+  defer os.RemoveAll(root)
+
+  writeTestFiles(t, root, map[string]string {
+    "qt/QtFoo/qmldir": "module QtFoo\n",
+    "extra/Vendor/Widgets/qmldir": "module Vendor.Widgets\n",
+    "extra/Vendor/Widgets/Widget.qml": "import QtFoo 1.0\nItem {}\n",
+    "outside/Evil/qmldir": "module Evil\n",
+  })
+
+  ad, recorder := newRecordingDeployer()
+  ad.qtDeployer = &QtDeployer{
+    qtEnv: map[QMakeKey]string{ QT_INSTALL_QML: root + "/qt" },
+    qmlImportDirs: []string{ root + "/extra" },
+    deployedQmlImports: newQmlImportNode(),
+  }
+
+  ad.processQmlImports([]QmlImport{
+    { Name: "Vendor.Widgets", Path: root + "/extra/Vendor/Widgets", ImportType: "module" },
+    { Name: "Evil", Path: root + "/qt/../outside/Evil", ImportType: "module" },
+  })
+  _, copied := recorder.finish(ad)
+
+  // paths are relative to the import path the module was found in
+  expected := []string{ "QtFoo/qmldir", "Vendor/Widgets/Widget.qml", "Vendor/Widgets/qmldir" }
+  if !reflect.DeepEqual(copied, expected) {
+    t.Errorf("Expected %v but got %v", expected, copied)
+  }
+}
+
+// sets the command line flag until the end of the test
+func setTestFlag(t *testing.T, name, value string) {
+  f := flag.Lookup(name)
+  previous := f.Value.String()
+  if err := f.Value.Set(value); err != nil { t.Fatal(err) }
+  t.Cleanup(func() { f.Value.Set(previous) })
+}
+
+func TestCreateTarball(t *testing.T) {
+  root, err := ioutil.TempDir("", "tarball")
+  if err != nil { t.Fatal(err) }
+  defer os.RemoveAll(root)
+
+  appDir := root + "/myexe.AppDir"
+  writeTestFiles(t, appDir, map[string]string {
+    "myexe": "#!/bin/sh\n",
+    "lib/libfoo.so.1": "library",
+  })
+  os.Chmod(appDir + "/myexe", 0755)
+  os.Symlink("myexe", appDir + "/AppRun")
+
+  ad := &AppDeployer{ destinationRoot: appDir, targetExePath: "/build/myexe", config: &ProjectConfig{} }
+
+  setTestFlag(t, "tarball-output", root + "/out.tar.gz")
+  setTestFlag(t, "tarball-root", "myroot")
+
+  if err = ad.createTarball(); err != nil { t.Fatal(err) }
+
+  f, err := os.Open(root + "/out.tar.gz")
+  if err != nil { t.Fatal(err) }
+  defer f.Close()
+
+  gr, err := gzip.NewReader(f)
+  if err != nil { t.Fatal(err) }
+
+  headers := make(map[string]*tar.Header)
+  tr := tar.NewReader(gr)
+  for {
+    header, err := tr.Next()
+    if err == io.EOF { break }
+    if err != nil { t.Fatal(err) }
+
+    if header.Name != "myroot/" && !strings.HasPrefix(header.Name, "myroot/") {
+      t.Errorf("Entry %v is outside of the root directory", header.Name)
+    }
+    headers[header.Name] = header
+  }
+
+  if header := headers["myroot/myexe"]; header == nil || header.Mode & 0777 != 0755 {
+    t.Errorf("Unexpected exe entry %v", header)
+  }
+
+  if header := headers["myroot/lib/libfoo.so.1"]; header == nil || header.Mode & 0777 != 0644 || header.Size != 7 {
+    t.Errorf("Unexpected library entry %v", header)
+  }
+
+  if header := headers["myroot/AppRun"]; header == nil || header.Typeflag != tar.TypeSymlink || header.Linkname != "myexe" {
+    t.Errorf("Unexpected AppRun entry %v", header)
+  }
+
+  if header := headers["myroot/bin/myexe"]; header == nil || header.Mode & 0111 == 0 {
+    t.Errorf("Launcher is missing or not executable: %v", header)
+  }
+}
+
+func TestGenerateDesktopFileExec(t *testing.T) {
+  appDir, err := ioutil.TempDir("", "desktop")
+  if err != nil { t.Fatal(err) }
+  defer os.RemoveAll(appDir)
+
+  ad := &AppDeployer{ destinationRoot: appDir, destinationExePath: appDir + "/myexe" }
+
+  tests := []struct {
+    outType string
+    exec string
+  }{
+    { "appimage", "Exec=./AppRun %F" },
+    { "dir", "Exec=myexe %F" },
+  }
+
+  for _, test := range tests {
+    setTestFlag(t, "out", test.outType)
+    ad.generateDesktopFile()
+
+    contents, err := ioutil.ReadFile(ad.destinationRoot + "/myexe.desktop")
+    if err != nil { t.Fatal(err) }
+
+    if !strings.Contains(string(contents), "\n" + test.exec + "\n") {
+      t.Errorf("Expected %v for %v output but got:\n%s", test.exec, test.outType, contents)
+    }
+  }
+}
+
+// returns members of the ar archive by name
+func readArMembers(t *testing.T, contents []byte) map[string][]byte {
+  if !bytes.HasPrefix(contents, []byte("!<arch>\n")) { t.Fatal("Missing ar magic") }
+
+  members := make(map[string][]byte)
+  names := make([]string, 0, 3)
+  offset := 8
+
+  for offset < len(contents) {
+    if offset + 60 > len(contents) { t.Fatalf("Truncated ar header at %v", offset) }
+    header := string(contents[offset:offset + 60])
+    if header[58:] != "`\n" { t.Fatalf("Bad ar header terminator at %v", offset) }
+
+    name := strings.TrimSpace(header[:16])
+    size, err := strconv.Atoi(strings.TrimSpace(header[48:58]))
+    if err != nil { t.Fatal(err) }
+
+    offset += 60
+    if offset + size > len(contents) { t.Fatalf("Truncated ar member %v", name) }
+    members[name] = contents[offset:offset + size]
+    names = append(names, name)
+
+    offset += size + size % 2
+  }
+
+  if !reflect.DeepEqual(names, []string{ "debian-binary", "control.tar.gz", "data.tar.gz" }) {
+    t.Errorf("Unexpected ar members order %v", names)
+  }
+
+  return members
+}
+
+// returns contents of regular files and headers of all entries in the tar.gz
+func readTarGz(t *testing.T, contents []byte) (map[string]*tar.Header, map[string][]byte) {
+  gr, err := gzip.NewReader(bytes.NewReader(contents))
+  if err != nil { t.Fatal(err) }
+
+  headers := make(map[string]*tar.Header)
+  files := make(map[string][]byte)
+  tr := tar.NewReader(gr)
+  for {
+    header, err := tr.Next()
+    if err == io.EOF { break }
+    if err != nil { t.Fatal(err) }
+
+    headers[header.Name] = header
+    if header.Typeflag == tar.TypeReg {
+      data, err := ioutil.ReadAll(tr)
+      if err != nil { t.Fatal(err) }
+      files[header.Name] = data
+    }
+  }
+
+  return headers, files
+}
+
+func TestCreateDebPackage(t *testing.T) {
+  root, err := ioutil.TempDir("", "deb")
+  if err != nil { t.Fatal(err) }
+  defer os.RemoveAll(root)
+
+  appDir := root + "/myexe.AppDir"
+  writeTestFiles(t, appDir, map[string]string { "myexe": "", "lib/libfoo.so.1": "library" })
+
+  // architecture is read from the ELF header of the target exe
+  testExe, err := os.Executable()
+  if err != nil { t.Fatal(err) }
+  if err = os.Symlink(testExe, root + "/myexe"); err != nil { t.Fatal(err) }
+
+  ad := &AppDeployer{ destinationRoot: appDir, targetExePath: root + "/myexe", config: &ProjectConfig{} }
+
+  setTestFlag(t, "pkg-output", root + "/out.deb")
+  setTestFlag(t, "pkg-version", "1.2")
+
+  if err = ad.createDebPackage(); err != nil { t.Fatal(err) }
+
+  contents, err := ioutil.ReadFile(root + "/out.deb")
+  if err != nil { t.Fatal(err) }
+
+  members := readArMembers(t, contents)
+  if string(members["debian-binary"]) != "2.0\n" {
+    t.Errorf("Unexpected debian-binary %q", members["debian-binary"])
+  }
+
+  _, controlFiles := readTarGz(t, members["control.tar.gz"])
+
+  control := string(controlFiles["./control"])
+  if !strings.Contains(control, "Package: myexe\n") || !strings.Contains(control, "Version: 1.2-1\n") {
+    t.Errorf("Unexpected control file:\n%s", control)
+  }
+
+  md5sums := string(controlFiles["./md5sums"])
+  if !strings.Contains(md5sums, "  opt/myexe/lib/libfoo.so.1\n") || !strings.Contains(md5sums, "  usr/share/applications/myexe.desktop\n") {
+    t.Errorf("Unexpected md5sums:\n%s", md5sums)
+  }
+
+  dataHeaders, _ := readTarGz(t, members["data.tar.gz"])
+  for name := range dataHeaders {
+    if !strings.HasPrefix(name, "./") { t.Errorf("Data entry %v does not start with ./", name) }
+  }
+
+  if header := dataHeaders["./opt/myexe/lib/libfoo.so.1"]; header == nil || header.Mode & 0777 != 0644 {
+    t.Errorf("Unexpected library entry %v", header)
+  }
+
+  if header := dataHeaders["./usr/bin/myexe"]; header == nil || header.Typeflag != tar.TypeSymlink || header.Linkname != "/opt/myexe/bin/myexe" {
+    t.Errorf("Unexpected launcher symlink %v", header)
+  }
+}
+
+// index entries and data store of the parsed rpm header
+type rpmTestHeader struct {
+  entries map[uint32]rpmHeaderEntry
+  store []byte
+}
+
+// parses header at the start of data and returns it with its size
+func readRpmHeader(t *testing.T, data []byte) (*rpmTestHeader, int) {
+  if len(data) < 16 || !bytes.Equal(data[:8], rpmHeaderMagic) { t.Fatal("Missing rpm header magic") }
+
+  indexCount := int(binary.BigEndian.Uint32(data[8:12]))
+  storeSize := int(binary.BigEndian.Uint32(data[12:16]))
+  size := 16 + indexCount * 16 + storeSize
+  if size > len(data) { t.Fatalf("Truncated rpm header of %v bytes", size) }
+
+  h := &rpmTestHeader{ entries: make(map[uint32]rpmHeaderEntry), store: data[16 + indexCount * 16:size] }
+  for i := 0; i < indexCount; i++ {
+    entry := data[16 + i * 16:]
+    offset := binary.BigEndian.Uint32(entry[8:12])
+    if int(offset) >= storeSize { t.Fatalf("Entry %v points outside of the store", i) }
+
+    tag := binary.BigEndian.Uint32(entry[0:4])
+    h.entries[tag] = rpmHeaderEntry{
+      tag: tag,
+      tagType: RpmTagType(binary.BigEndian.Uint32(entry[4:8])),
+      count: binary.BigEndian.Uint32(entry[12:16]),
+      data: h.store[offset:],
+    }
+  }
+
+  return h, size
+}
+
+func (h *rpmTestHeader) strings(t *testing.T, tag uint32) []string {
+  entry, ok := h.entries[tag]
+  if !ok { t.Fatalf("Missing tag %v", tag) }
+
+  values := strings.SplitN(string(entry.data), "\x00", int(entry.count) + 1)
+  if len(values) <= int(entry.count) { t.Fatalf("Truncated strings of tag %v", tag) }
+  return values[:entry.count]
+}
+
+func (h *rpmTestHeader) uint32s(t *testing.T, tag uint32) []uint32 {
+  entry, ok := h.entries[tag]
+  if !ok || entry.tagType != RPM_INT32_TYPE { t.Fatalf("Missing int32 tag %v", tag) }
+
+  values := make([]uint32, entry.count)
+  for i := range values {
+    values[i] = binary.BigEndian.Uint32(entry.data[i * 4:])
+  }
+  return values
+}
+
+// reads newc cpio archive and returns contents of entries by name
+func readCpio(t *testing.T, data []byte) map[string][]byte {
+  files := make(map[string][]byte)
+  offset := 0
+  align := func(value int) int { return (value + 3) &^ 3 }
+
+  for {
+    if offset + 110 > len(data) || string(data[offset:offset + 6]) != "070701" { t.Fatalf("Bad cpio header at %v", offset) }
+
+    field := func(index int) int {
+      value, err := strconv.ParseUint(string(data[offset + 6 + index * 8:offset + 14 + index * 8]), 16, 32)
+      if err != nil { t.Fatal(err) }
+      return int(value)
+    }
+
+    size, nameSize := field(6), field(11)
+    name := string(data[offset + 110:offset + 110 + nameSize - 1])
+    offset = align(offset + 110 + nameSize)
+    if name == "TRAILER!!!" { break }
+
+    files[name] = data[offset:offset + size]
+    offset = align(offset + size)
+  }
+
+  return files
+}
+
+func TestCreateRpmPackage(t *testing.T) {
+  root, err := ioutil.TempDir("", "rpm")
+  if err != nil { t.Fatal(err) }
+  defer os.RemoveAll(root)
+
+  appDir := root + "/myexe.AppDir"
+  writeTestFiles(t, appDir, map[string]string { "myexe": "", "lib/libfoo.so.1": "library" })
+
+  // architecture is read from the ELF header of the target exe
+  testExe, err := os.Executable()
+  if err != nil { t.Fatal(err) }
+  if err = os.Symlink(testExe, root + "/myexe"); err != nil { t.Fatal(err) }
+
+  ad := &AppDeployer{ destinationRoot: appDir, targetExePath: root + "/myexe", config: &ProjectConfig{} }
+
+  setTestFlag(t, "pkg-output", root + "/out.rpm")
+  setTestFlag(t, "pkg-version", "1.2")
+
+  if err = ad.createRpmPackage(); err != nil { t.Fatal(err) }
+
+  data, err := ioutil.ReadFile(root + "/out.rpm")
+  if err != nil { t.Fatal(err) }
+
+  if len(data) < rpmLeadSize || !bytes.HasPrefix(data, []byte{0xed, 0xab, 0xee, 0xdb, 3, 0}) { t.Fatal("Missing rpm lead") }
+  if name := string(bytes.TrimRight(data[10:76], "\x00")); name != "myexe-1.2-1" {
+    t.Errorf("Unexpected lead name %v", name)
+  }
+
+  signature, signatureSize := readRpmHeader(t, data[rpmLeadSize:])
+  headerStart := rpmLeadSize + (signatureSize + 7) &^ 7
+  header, headerSize := readRpmHeader(t, data[headerStart:])
+  headerBytes := data[headerStart:headerStart + headerSize]
+  payload := data[headerStart + headerSize:]
+
+  if size := signature.uint32s(t, RPMSIGTAG_SIZE)[0]; int(size) != headerSize + len(payload) {
+    t.Errorf("Signature size %v does not match header and payload size %v", size, headerSize + len(payload))
+  }
+
+  if sum := md5.Sum(data[headerStart:]); !bytes.Equal(signature.entries[RPMSIGTAG_MD5].data[:16], sum[:]) {
+    t.Error("Signature MD5 does not match header and payload")
+  }
+
+  if sha := signature.strings(t, RPMSIGTAG_SHA256)[0]; sha != fmt.Sprintf("%x", sha256.Sum256(headerBytes)) {
+    t.Error("Signature SHA256 does not match header")
+  }
+
+  if name := header.strings(t, RPMTAG_NAME)[0]; name != "myexe" {
+    t.Errorf("Unexpected package name %v", name)
+  }
+
+  basenames := header.strings(t, RPMTAG_BASENAMES)
+  dirnames := header.strings(t, RPMTAG_DIRNAMES)
+  dirIndexes := header.uint32s(t, RPMTAG_DIRINDEXES)
+  sizes := header.uint32s(t, RPMTAG_FILESIZES)
+  if len(dirIndexes) != len(basenames) || len(sizes) != len(basenames) {
+    t.Fatalf("File tags have different counts: %v %v %v", len(basenames), len(dirIndexes), len(sizes))
+  }
+
+  gr, err := gzip.NewReader(bytes.NewReader(payload))
+  if err != nil { t.Fatal(err) }
+  archive, err := ioutil.ReadAll(gr)
+  if err != nil { t.Fatal(err) }
+
+  if size := signature.uint32s(t, RPMSIGTAG_PAYLOADSIZE)[0]; int(size) != len(archive) {
+    t.Errorf("Payload size %v does not match cpio size %v", size, len(archive))
+  }
+
+  // every file of the header is in the payload
+  files := readCpio(t, archive)
+  for i, basename := range basenames {
+    name := "." + dirnames[dirIndexes[i]] + basename
+    contents, ok := files[name]
+    if !ok { t.Errorf("File %v is missing in the payload", name); continue }
+    if len(contents) != 0 && len(contents) != int(sizes[i]) {
+      t.Errorf("File %v has %v bytes but header says %v", name, len(contents), sizes[i])
+    }
+  }
+
+  if len(files) != len(basenames) {
+    t.Errorf("Payload has %v files but header lists %v", len(files), len(basenames))
+  }
+
+  if contents := files["./opt/myexe/lib/libfoo.so.1"]; string(contents) != "library" {
+    t.Errorf("Unexpected library contents %q", contents)
+  }
+
+  if link := files["./usr/bin/myexe"]; string(link) != "/opt/myexe/bin/myexe" {
+    t.Errorf("Unexpected launcher symlink %q", link)
+  }
+
+  // cpio can not store files of 4 GiB and more
+  if err = os.Truncate(appDir + "/lib/libfoo.so.1", rpmMaxFileSize + 1); err != nil { t.Fatal(err) }
+  if err = ad.createRpmPackage(); err == nil {
+    t.Error("Expected error for file which does not fit into cpio")
+  }
+}
+
+func TestCreateSelfExtractingInstaller(t *testing.T) {
+  root, err := ioutil.TempDir("", "installer")
+  if err != nil { t.Fatal(err) }
+  defer os.RemoveAll(root)
+
+  appDir := root + "/myexe.AppDir"
+  writeTestFiles(t, appDir, map[string]string { "myexe": "#!/bin/sh\n" })
+  os.Chmod(appDir + "/myexe", 0755)
+
+  ad := &AppDeployer{ destinationRoot: appDir, targetExePath: "/build/myexe", config: &ProjectConfig{} }
+
+  setTestFlag(t, "pkg-output", root + "/out.run")
+
+  if err = ad.createSelfExtractingInstaller(); err != nil { t.Fatal(err) }
+
+  contents, err := ioutil.ReadFile(root + "/out.run")
+  if err != nil { t.Fatal(err) }
+
+  if !bytes.HasPrefix(contents, []byte("#!/bin/sh\n")) { t.Fatal("Installer does not start with shebang") }
+  if !bytes.Contains(contents, []byte("APP=\"myexe\"\n")) { t.Error("Installer does not set APP") }
+
+  marker := []byte("tail -n +")
+  index := bytes.Index(contents, marker)
+  if index == -1 { t.Fatal("Payload offset is not found") }
+  fields := strings.Fields(string(contents[index + len(marker):index + bytes.IndexByte(contents[index:], '\n')]))
+  payloadLine, err := strconv.Atoi(fields[0])
+  if err != nil { t.Fatal(err) }
+
+  // payload starts right after the header line with "exit 0"
+  lines := bytes.SplitAfterN(contents, []byte("\n"), payloadLine)
+  if string(lines[payloadLine - 2]) != "exit 0\n" {
+    t.Errorf("Header does not end before line %v: %q", payloadLine, lines[payloadLine - 2])
+  }
+
+  headers, _ := readTarGz(t, lines[payloadLine - 1])
+  if header := headers["myexe"]; header == nil || header.Mode & 0777 != 0755 {
+    t.Errorf("Unexpected exe entry %v", header)
+  }
+
+  if header := headers["bin/myexe"]; header == nil {
+    t.Error("Launcher is missing in the payload")
+  }
+
+  output, err := exec.Command("/bin/sh", root + "/out.run", "--target").CombinedOutput()
+  if err == nil || !strings.Contains(string(output), "Option --target requires a directory") {
+    t.Errorf("Expected usage error for --target without value but got %v: %s", err, output)
+  }
+}
+
+func TestSelfExtractingInstallerDesktopHook(t *testing.T) {
+  root, err := ioutil.TempDir("", "installer")
+  if err != nil { t.Fatal(err) }
+  defer os.RemoveAll(root)
+
+  appDir := root + "/myexe.AppDir"
+  writeTestFiles(t, appDir, map[string]string { "myexe": "#!/bin/sh\n" })
+
+  ad := &AppDeployer{
+    destinationRoot: appDir,
+    targetExePath: "/build/myexe",
+    destinationExePath: appDir + "/myexe",
+    config: &ProjectConfig{},
+  }
+
+  setTestFlag(t, "pkg-output", root + "/out.run")
+  setTestFlag(t, "run-desktop-hook", "true")
+  setTestFlag(t, "out", "run")
+
+  if err = ad.createSelfExtractingInstaller(); err != nil { t.Fatal(err) }
+
+  // target with characters which are special for sed and for the desktop entry
+  target := root + "/a|b&c\"d$e`f\\g"
+  cmd := exec.Command("/bin/sh", root + "/out.run", "--target", target)
+  cmd.Env = append(os.Environ(), "XDG_DATA_HOME=" + root + "/data")
+  if output, err := cmd.CombinedOutput(); err != nil { t.Fatalf("Installer failed: %v %s", err, output) }
+
+  contents, err := ioutil.ReadFile(root + "/data/applications/myexe.desktop")
+  if err != nil { t.Fatal(err) }
+
+  escaped := strings.NewReplacer(`\`, `\\\\`, `"`, `\\"`, "$", `\\$`, "`", "\\\\`").Replace(target + "/bin/myexe")
+  if !strings.Contains(string(contents), "\nExec=\"" + escaped + "\" %F\n") {
+    t.Errorf("Unexpected desktop file:\n%s", contents)
+  }
+
+  // target must not break Exec into several lines
+  for _, line := range strings.Split(strings.TrimSpace(string(contents)), "\n")[1:] {
+    if !strings.Contains(line, "=") { t.Errorf("Unexpected desktop file line %q", line) }
+  }
+
+  newlineTarget := root + "/new\nline"
+  cmd = exec.Command("/bin/sh", root + "/out.run", "--target", newlineTarget)
+  cmd.Env = append(os.Environ(), "XDG_DATA_HOME=" + root + "/newline-data")
+  if output, err := cmd.CombinedOutput(); err != nil || !strings.Contains(string(output), "Desktop file is not installed") {
+    t.Errorf("Expected desktop file to be skipped but got %v: %s", err, output)
+  }
+}
+
+// reads the blob and checks that it matches the descriptor
+func readOciBlob(t *testing.T, layoutPath string, descriptor OciDescriptor) []byte {
+  if !strings.HasPrefix(descriptor.Digest, "sha256:") { t.Fatalf("Unexpected digest %v", descriptor.Digest) }
+
+  data, err := ioutil.ReadFile(layoutPath + "/blobs/sha256/" + strings.TrimPrefix(descriptor.Digest, "sha256:"))
+  if err != nil { t.Fatal(err) }
+
+  if digest := fmt.Sprintf("sha256:%x", sha256.Sum256(data)); digest != descriptor.Digest {
+    t.Errorf("Blob digest %v does not match descriptor %v", digest, descriptor.Digest)
+  }
+
+  if int64(len(data)) != descriptor.Size {
+    t.Errorf("Blob %v size %v does not match descriptor size %v", descriptor.Digest, len(data), descriptor.Size)
+  }
+
+  return data
+}
+
+func TestCreateOciImage(t *testing.T) {
+  root, err := ioutil.TempDir("", "oci")
+  if err != nil { t.Fatal(err) }
+  defer os.RemoveAll(root)
+
+  appDir := root + "/myexe.AppDir"
+  writeTestFiles(t, appDir, map[string]string { "myexe": "", "lib/libfoo.so.1": "library" })
+
+  // architecture of the image is read from the ELF header of the target exe
+  testExe, err := os.Executable()
+  if err != nil { t.Fatal(err) }
+  if err = os.Symlink(testExe, root + "/myexe"); err != nil { t.Fatal(err) }
+
+  ad := &AppDeployer{
+    destinationRoot: appDir,
+    targetExePath: root + "/myexe",
+    destinationExePath: appDir + "/myexe",
+    config: &ProjectConfig{},
+  }
+
+  layoutPath := root + "/oci"
+  setTestFlag(t, "pkg-output", layoutPath)
+  setTestFlag(t, "pkg-version", "1.2")
+
+  if err = ad.createOciImage(); err != nil { t.Fatal(err) }
+
+  var layout map[string]string
+  data, err := ioutil.ReadFile(layoutPath + "/oci-layout")
+  if err != nil { t.Fatal(err) }
+  if err = json.Unmarshal(data, &layout); err != nil { t.Fatal(err) }
+  if layout["imageLayoutVersion"] != ociLayoutVersion {
+    t.Errorf("Unexpected oci-layout %s", data)
+  }
+
+  var index OciIndex
+  data, err = ioutil.ReadFile(layoutPath + "/index.json")
+  if err != nil { t.Fatal(err) }
+  if err = json.Unmarshal(data, &index); err != nil { t.Fatal(err) }
+
+  if index.SchemaVersion != 2 || len(index.Manifests) != 1 {
+    t.Fatalf("Unexpected index %s", data)
+  }
+
+  manifestDescriptor := index.Manifests[0]
+  if manifestDescriptor.MediaType != ociManifestMediaType || manifestDescriptor.Annotations[ociRefNameAnnotation] != "1.2" {
+    t.Errorf("Unexpected manifest descriptor %v", manifestDescriptor)
+  }
+
+  var manifest OciManifest
+  if err = json.Unmarshal(readOciBlob(t, layoutPath, manifestDescriptor), &manifest); err != nil { t.Fatal(err) }
+  if len(manifest.Layers) != 1 || manifest.Layers[0].MediaType != ociLayerMediaType {
+    t.Fatalf("Unexpected manifest %v", manifest)
+  }
+
+  var config OciImageConfig
+  if err = json.Unmarshal(readOciBlob(t, layoutPath, manifest.Config), &config); err != nil { t.Fatal(err) }
+  if len(config.Config.Entrypoint) != 1 || config.Config.Entrypoint[0] != "/opt/myexe/myexe" {
+    t.Errorf("Unexpected entrypoint %v", config.Config.Entrypoint)
+  }
+
+  layer := readOciBlob(t, layoutPath, manifest.Layers[0])
+  gr, err := gzip.NewReader(bytes.NewReader(layer))
+  if err != nil { t.Fatal(err) }
+  uncompressed, err := ioutil.ReadAll(gr)
+  if err != nil { t.Fatal(err) }
+
+  if diffID := fmt.Sprintf("sha256:%x", sha256.Sum256(uncompressed)); len(config.RootFS.DiffIDs) != 1 || config.RootFS.DiffIDs[0] != diffID {
+    t.Errorf("Diff ids %v do not match the layer %v", config.RootFS.DiffIDs, diffID)
+  }
+
+  headers, _ := readTarGz(t, layer)
+  if header := headers["opt/myexe/lib/libfoo.so.1"]; header == nil || header.Mode & 0777 != 0644 {
+    t.Errorf("Unexpected library entry %v", header)
+  }
+
+  if _, ok := headers["/"]; ok {
+    t.Error("Layer must not have an entry for the root")
+  }
+}
+
+func TestGenerateAppRunScript(t *testing.T) {
+  appDir, err := ioutil.TempDir("", "apprun")
+  if err != nil { t.Fatal(err) }
+  defer os.RemoveAll(appDir)
+
+  writeTestFiles(t, appDir, map[string]string { "myexe": "", "lib/libfoo.so.1": "library" })
+  os.Symlink("myexe", appDir + "/AppRun")
+
+  ad := &AppDeployer{
+    destinationRoot: appDir,
+    destinationExePath: appDir + "/myexe",
+    config: &ProjectConfig{ Environment: map[string]string{ "MY_DATA": "$APPDIR/data \"quoted\"" } },
+  }
+
+  if err = ad.generateAppRunScript(); err != nil { t.Fatal(err) }
+
+  scriptPath := ad.destinationRoot + "/AppRun"
+  info, err := os.Lstat(scriptPath)
+  if err != nil { t.Fatal(err) }
+  if !info.Mode().IsRegular() || info.Mode() & 0111 == 0 {
+    t.Fatalf("AppRun is not an executable file: %v", info.Mode())
+  }
+
+  contents, err := ioutil.ReadFile(scriptPath)
+  if err != nil { t.Fatal(err) }
+  script := string(contents)
+
+  expected := []string{
+    "export LD_LIBRARY_PATH=\"$APPDIR/lib${LD_LIBRARY_PATH:+:$LD_LIBRARY_PATH}\"\n",
+    "export XDG_DATA_DIRS=\"$APPDIR/share:${XDG_DATA_DIRS:-/usr/local/share:/usr/share}\"\n",
+    "export MY_DATA=\"$APPDIR/data \\\"quoted\\\"\"\n",
+    "exec \"$APPDIR/myexe\" \"$@\"\n",
+  }
+
+  for _, line := range expected {
+    if !strings.Contains(script, line) { t.Errorf("AppRun does not contain %q:\n%s", line, script) }
+  }
+
+  // plugins dir is not deployed
+  if strings.Contains(script, "QT_PLUGIN_PATH") { t.Errorf("AppRun exports QT_PLUGIN_PATH:\n%s", script) }
+
+  if output, err := exec.Command("/bin/sh", "-n", scriptPath).CombinedOutput(); err != nil {
+    t.Errorf("AppRun is not a valid shell script: %v %s", err, output)
+  }
+}
+
+func TestLoadProjectConfigRejectsInvalidEnvironment(t *testing.T) {
+  f, err := ioutil.TempFile("", "config")
+  if err != nil { t.Fatal(err) }
+  defer os.Remove(f.Name())
+
+  f.WriteString(`{"environment": {"MY-VAR": "value"}}`)
+  f.Close()
+
+  if _, err = loadProjectConfig(f.Name()); err == nil {
+    t.Error("Expected error for invalid environment variable name")
+  }
+
+  ioutil.WriteFile(f.Name(), []byte(`{"environment": {"_MY_VAR2": "value"}}`), 0644)
+  if config, err := loadProjectConfig(f.Name()); err != nil || config.Environment["_MY_VAR2"] != "value" {
+    t.Errorf("Unexpected config %v (%v)", config, err)
+  }
+}
+
+func TestAccountTracedLibraries(t *testing.T) {
+  appDir, err := ioutil.TempDir("", "traced")
+  if err != nil { t.Fatal(err) }
+  defer os.RemoveAll(appDir)
+
+  writeTestFiles(t, appDir, map[string]string { "lib/libfoo.so.1": "library" })
+
+  ad := &AppDeployer{ destinationRoot: appDir, report: NewDeployReport() }
+  // libEGL was left to the host so it is missing in the AppDir
+  ad.accountTracedLibraries([]string{ "/usr/lib/libfoo.so.1", "/usr/lib/libEGL.so.1" })
+
+  expected := []ExtraLibrary{ { Path: "/usr/lib/libfoo.so.1", Origin: runtimeTraceOrigin } }
+  if !reflect.DeepEqual(ad.report.ExtraLibraries, expected) {
+    t.Errorf("Expected %v but got %v", expected, ad.report.ExtraLibraries)
+  }
+}
+
+func TestXzWriter(t *testing.T) {
+  // repeated text is compressed, random bytes are stored in uncompressed chunks
+  data := bytes.Repeat([]byte("linuxdeploy writes .tar.xz without external tools\n"), 4000)
+  random := make([]byte, 100000)
+  for i := range random {
+    random[i] = byte(i * 7919 >> 3 ^ i * 104729)
+  }
+  data = append(data, random...)
+
+  var buffer bytes.Buffer
+  xw := newXzWriter(&buffer)
+  if _, err := xw.Write(data); err != nil { t.Fatal(err) }
+  if err := xw.Close(); err != nil { t.Fatal(err) }
+
+  compressed := buffer.Bytes()
+  if !bytes.HasPrefix(compressed, xzStreamMagic) || !bytes.HasSuffix(compressed, []byte("YZ")) || len(compressed) % 4 != 0 {
+    t.Fatalf("Invalid xz stream framing")
+  }
+
+  if len(compressed) >= len(data) {
+    t.Errorf("Data is not compressed: %v >= %v", len(compressed), len(data))
+  }
+
+  xzPath, err := exec.LookPath("xz")
+  if err != nil { t.Skip("xz is not installed, skipping decompression") }
+
+  cmd := exec.Command(xzPath, "--decompress", "--stdout")
+  cmd.Stdin = bytes.NewReader(compressed)
+  decompressed, err := cmd.Output()
+  if err != nil { t.Fatal(err) }
+
+  if !bytes.Equal(decompressed, data) {
+    t.Errorf("Decompressed %v bytes do not match %v original bytes", len(decompressed), len(data))
+  }
+}
+
+func TestApplyRuntimeDependencyRules(t *testing.T) {
+  libdir, err := ioutil.TempDir("", "libs")
+  if err != nil { t.Fatal(err) }
+  defer os.RemoveAll(libdir)
+
+  // files are not ELF so SONAME falls back to the file name
+  writeTestFiles(t, libdir, map[string]string {
+    "libhost.so.1": "",
+    "libmain.so.1": "",
+    "libcompanion.so": "",
+    "modules/libmodule.so": "",
+  })
+
+  ad, recorder := newRecordingDeployer()
+  ad.qtDeployer = &QtDeployer{ qtEnv: map[QMakeKey]string{} }
+  ad.runtimeRules = []RuntimeDependencyRule {
+    { Soname: "libhost.so", Host: true, Reason: "drivers of the host" },
+    { Soname: "libmain.so", Libraries: []string{ "libcompanion.so", "libmissing.so" }, Dirs: []string{ "modules", "missing" } },
+  }
+
+  if ad.applyRuntimeDependencyRules(filepath.Join(libdir, "libhost.so.1")) {
+    t.Error("Host library has to be left to the host")
+  }
+
+  if !ad.applyRuntimeDependencyRules(filepath.Join(libdir, "libmain.so.1")) {
+    t.Error("Library with companions has to be deployed")
+  }
+
+  if !ad.applyRuntimeDependencyRules(filepath.Join(libdir, "libother.so.2")) {
+    t.Error("Library without rules has to be deployed")
+  }
+
+  libs, _ := recorder.finish(ad)
+
+  expectedLibs := []string{ filepath.Join(libdir, "libcompanion.so"), "modules/libmodule.so" }
+  if !reflect.DeepEqual(libs, expectedLibs) {
+    t.Errorf("Expected %v but got %v", expectedLibs, libs)
+  }
+
+  expectedHost := []HostLibrary{ { Path: filepath.Join(libdir, "libhost.so.1"), Reason: "drivers of the host" } }
+  if !reflect.DeepEqual(ad.report.HostLibraries, expectedHost) {
+    t.Errorf("Expected %v but got %v", expectedHost, ad.report.HostLibraries)
+  }
+}
+
+func TestCreateAppImageWithoutTools(t *testing.T) {
+  appDir, err := ioutil.TempDir("", "appimage")
+  if err != nil { t.Fatal(err) }
+  defer os.RemoveAll(appDir)
+
+  // neither mksquashfs nor the runtime can be found
+  t.Setenv("PATH", appDir)
+  ad := &AppDeployer{ destinationRoot: appDir }
+
+  defer func(requested bool) { appImageRequested = requested }(appImageRequested)
+
+  appImageRequested = false
+  if err = ad.createAppImage(); err != nil {
+    t.Errorf("Default AppImage output has to be skipped but got %v", err)
+  }
+
+  appImageRequested = true
+  if err = ad.createAppImage(); err == nil {
+    t.Error("Expected error for explicitly requested AppImage")
+  }
+}
@@ -0,0 +1,245 @@
+/*
+ * This file is a part of linuxdeploy - tool for
+ * creating standalone applications for Linux
+ *
+ * Copyright (C) 2017 Taras Kushnir <kushnirTV@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the MIT License.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ */
+
+package main
+
+import (
+  "log"
+  "os"
+  "io"
+  "fmt"
+  "time"
+  "errors"
+  "sort"
+  "strings"
+  "crypto/sha256"
+  "archive/tar"
+  "compress/gzip"
+  "debug/elf"
+  "encoding/json"
+  "io/ioutil"
+  "path"
+  "path/filepath"
+)
+
+const (
+  ociLayoutVersion = "1.0.0"
+  ociIndexMediaType = "application/vnd.oci.image.index.v1+json"
+  ociManifestMediaType = "application/vnd.oci.image.manifest.v1+json"
+  ociConfigMediaType = "application/vnd.oci.image.config.v1+json"
+  ociLayerMediaType = "application/vnd.oci.image.layer.v1.tar+gzip"
+  ociRefNameAnnotation = "org.opencontainers.image.ref.name"
+)
+
+type OciDescriptor struct {
+  MediaType string `json:"mediaType"`
+  Digest string `json:"digest"`
+  Size int64 `json:"size"`
+  Annotations map[string]string `json:"annotations,omitempty"`
+  Platform *OciPlatform `json:"platform,omitempty"`
+}
+
+type OciPlatform struct {
+  Architecture string `json:"architecture"`
+  OS string `json:"os"`
+  Variant string `json:"variant,omitempty"`
+}
+
+type OciIndex struct {
+  SchemaVersion int `json:"schemaVersion"`
+  MediaType string `json:"mediaType"`
+  Manifests []OciDescriptor `json:"manifests"`
+}
+
+type OciManifest struct {
+  SchemaVersion int `json:"schemaVersion"`
+  MediaType string `json:"mediaType"`
+  Config OciDescriptor `json:"config"`
+  Layers []OciDescriptor `json:"layers"`
+}
+
+type OciImageConfig struct {
+  Created string `json:"created"`
+  Architecture string `json:"architecture"`
+  OS string `json:"os"`
+  Variant string `json:"variant,omitempty"`
+  Config OciRuntimeConfig `json:"config"`
+  RootFS OciRootFS `json:"rootfs"`
+  History []OciHistory `json:"history"`
+}
+
+type OciRuntimeConfig struct {
+  Entrypoint []string `json:"Entrypoint"`
+  Env []string `json:"Env"`
+  WorkingDir string `json:"WorkingDir"`
+}
+
+type OciRootFS struct {
+  Type string `json:"type"`
+  DiffIDs []string `json:"diff_ids"`
+}
+
+type OciHistory struct {
+  Created string `json:"created"`
+  CreatedBy string `json:"created_by"`
+}
+
+func (ad *AppDeployer) createOciImage() error {
+  metadata := ad.packageMetadata()
+
+  launcherPath := filepath.Join("bin", ad.appName())
+  if err := ad.generateLauncherScript(launcherPath); err != nil { return err }
+
+  machine, err := elfMachine(ad.targetExePath)
+  if err != nil { return err }
+  platform := ociPlatform(machine)
+
+  outputPath := ad.packageOutputPath(fmt.Sprintf("%s-%s-oci", metadata.Name, metadata.Version))
+  if _, err := os.Stat(outputPath); err == nil {
+    if !(*overwriteFlag) { return errors.New("OCI output already exists. Please set overwrite flag to overwrite it") }
+    os.RemoveAll(outputPath)
+  }
+
+  blobsPath := filepath.Join(outputPath, "blobs", "sha256")
+  if err = os.MkdirAll(blobsPath, os.ModePerm); err != nil { return err }
+
+  log.Printf("Creating OCI image layout in %v", outputPath)
+
+  installRoot := path.Join("/opt", metadata.Name)
+  layer, diffID, err := ad.writeOciLayer(blobsPath, installRoot)
+  if err != nil { return err }
+
+  created := time.Now().UTC().Format(time.RFC3339)
+  env := []string{ "PATH=" + path.Join(installRoot, "bin") + ":/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin" }
+  for _, variable := range ad.deployedLauncherVariables() {
+    value := path.Join(installRoot, variable.relativePath)
+    if len(variable.fallback) > 0 { value += ":" + variable.fallback }
+    env = append(env, fmt.Sprintf("%s=%s", variable.name, value))
+  }
+  for name, value := range ad.config.Environment {
+    env = append(env, fmt.Sprintf("%s=%s", name, strings.Replace(value, "$APPDIR", installRoot, -1)))
+  }
+  sort.Strings(env[1:])
+
+  imageConfig := OciImageConfig{
+    Created: created,
+    Architecture: platform.Architecture,
+    OS: platform.OS,
+    Variant: platform.Variant,
+    Config: OciRuntimeConfig{
+      Entrypoint: []string{ path.Join(installRoot, filepath.Base(ad.destinationExePath)) },
+      Env: env,
+      WorkingDir: installRoot,
+    },
+    RootFS: OciRootFS{ Type: "layers", DiffIDs: []string{diffID} },
+    History: []OciHistory{ { Created: created, CreatedBy: appName } },
+  }
+
+  config, err := writeOciJsonBlob(blobsPath, ociConfigMediaType, imageConfig)
+  if err != nil { return err }
+
+  manifest, err := writeOciJsonBlob(blobsPath, ociManifestMediaType, OciManifest{
+    SchemaVersion: 2,
+    MediaType: ociManifestMediaType,
+    Config: config,
+    Layers: []OciDescriptor{layer},
+  })
+  if err != nil { return err }
+
+  manifest.Platform = &platform
+  manifest.Annotations = map[string]string{ ociRefNameAnnotation: metadata.Version }
+
+  index := OciIndex{
+    SchemaVersion: 2,
+    MediaType: ociIndexMediaType,
+    Manifests: []OciDescriptor{manifest},
+  }
+
+  if err = writeJsonFile(filepath.Join(outputPath, "index.json"), index); err != nil { return err }
+  if err = writeJsonFile(filepath.Join(outputPath, "oci-layout"), map[string]string{ "imageLayoutVersion": ociLayoutVersion }); err != nil { return err }
+
+  log.Printf("OCI image created at %v with tag %v", outputPath, metadata.Version)
+  return nil
+}
+
+func ociPlatform(machine elf.Machine) OciPlatform {
+  platform := OciPlatform{ OS: "linux" }
+
+  switch machine {
+  case elf.EM_X86_64: platform.Architecture = "amd64"
+  case elf.EM_386: platform.Architecture = "386"
+  case elf.EM_AARCH64: platform.Architecture = "arm64"
+  case elf.EM_ARM: platform.Architecture, platform.Variant = "arm", "v7"
+  case elf.EM_PPC64: platform.Architecture = "ppc64le"
+  case elf.EM_S390: platform.Architecture = "s390x"
+  default: platform.Architecture = strings.ToLower(strings.TrimPrefix(machine.String(), "EM_"))
+  }
+
+  return platform
+}
+
+// writes gzipped layer and returns its descriptor and digest of uncompressed tar
+func (ad *AppDeployer) writeOciLayer(blobsPath, installRoot string) (OciDescriptor, string, error) {
+  var descriptor OciDescriptor
+
+  layerFile, err := ioutil.TempFile(blobsPath, ".layer-")
+  if err != nil { return descriptor, "", err }
+  defer os.Remove(layerFile.Name())
+  defer layerFile.Close()
+
+  compressedHash := sha256.New()
+  uncompressedHash := sha256.New()
+  counter := &countingWriter{w: io.MultiWriter(layerFile, compressedHash)}
+
+  gw := gzip.NewWriter(counter)
+  tw := tar.NewWriter(io.MultiWriter(gw, uncompressedHash))
+
+  if err = newTarDirs(tw, "", time.Now()).ensure(path.Dir(installRoot)); err != nil { return descriptor, "", err }
+  if err = addTreeToTar(tw, ad.destinationRoot, strings.TrimPrefix(installRoot, "/")); err != nil { return descriptor, "", err }
+  if err = tw.Close(); err != nil { return descriptor, "", err }
+  if err = gw.Close(); err != nil { return descriptor, "", err }
+  if err = layerFile.Close(); err != nil { return descriptor, "", err }
+
+  digest := fmt.Sprintf("%x", compressedHash.Sum(nil))
+  if err = os.Rename(layerFile.Name(), filepath.Join(blobsPath, digest)); err != nil { return descriptor, "", err }
+
+  descriptor = OciDescriptor{
+    MediaType: ociLayerMediaType,
+    Digest: "sha256:" + digest,
+    Size: counter.count,
+  }
+
+  return descriptor, fmt.Sprintf("sha256:%x", uncompressedHash.Sum(nil)), nil
+}
+
+func writeOciJsonBlob(blobsPath, mediaType string, value interface{}) (OciDescriptor, error) {
+  data, err := json.Marshal(value)
+  if err != nil { return OciDescriptor{}, err }
+
+  digest := fmt.Sprintf("%x", sha256.Sum256(data))
+  if err = ioutil.WriteFile(filepath.Join(blobsPath, digest), data, 0644); err != nil { return OciDescriptor{}, err }
+
+  return OciDescriptor{
+    MediaType: mediaType,
+    Digest: "sha256:" + digest,
+    Size: int64(len(data)),
+  }, nil
+}
+
+func writeJsonFile(path string, value interface{}) error {
+  data, err := json.Marshal(value)
+  if err != nil { return err }
+
+  return ioutil.WriteFile(path, data, 0644)
+}
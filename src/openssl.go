@@ -0,0 +1,167 @@
+/*
+ * This file is a part of linuxdeploy - tool for
+ * creating standalone applications for Linux
+ *
+ * Copyright (C) 2017 Taras Kushnir <kushnirTV@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the MIT License.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ */
+
+package main
+
+import (
+  "log"
+  "os"
+  "os/exec"
+  "regexp"
+  "strings"
+  "io/ioutil"
+  "path/filepath"
+)
+
+// OPENSSL_VERSION_TEXT which Qt embeds for QSslSocket::sslLibraryBuildVersionString()
+var openSslVersionRegexp = regexp.MustCompile(`OpenSSL (\d+)\.(\d+)\.(\d+)[a-z]*`)
+
+var openSslLibraries = []string { "libssl.so", "libcrypto.so" }
+
+// Qt 6 moved OpenSSL support into the TLS backend plugin
+const qt6OpenSslBackendPlugin = "tls/libqopensslbackend.so"
+
+// finds OpenSSL version the binary was built against, e.g. 1.1.1k
+func findOpenSslVersion(contents []byte) []string {
+  match := openSslVersionRegexp.FindSubmatch(contents)
+  if match == nil { return nil }
+
+  return []string{ string(match[1]), string(match[2]), string(match[3]) }
+}
+
+// SONAME suffixes of OpenSSL libraries in the order Qt tries them
+func openSslSonameVersions(version []string) []string {
+  if len(version) < 3 { return nil }
+
+  if version[0] != "1" { return []string{ version[0] } }
+
+  if version[1] == "0" {
+    // distributions disagree about 1.0.x sonames
+    return []string{ "1.0." + version[2], "1.0.0", "10" }
+  }
+
+  return []string{ version[0] + "." + version[1] }
+}
+
+func (ad *AppDeployer) deployOpenSsl(qtNetworkPath string, qtVersion int) {
+  sourcePath := qtNetworkPath
+  if qtVersion >= 6 {
+    sourcePath = filepath.Join(ad.qtDeployer.PluginsPath(), qt6OpenSslBackendPlugin)
+  }
+
+  contents, err := ioutil.ReadFile(sourcePath)
+  if err != nil {
+    log.Printf("Cannot read %v to find OpenSSL version: %v", sourcePath, err)
+    return
+  }
+
+  version := findOpenSslVersion(contents)
+  if version == nil {
+    log.Printf("OpenSSL version is not found in %v", sourcePath)
+    return
+  }
+
+  log.Printf("Qt is built against OpenSSL %v", strings.Join(version, "."))
+
+  machine, err := elfMachine(ad.targetExePath)
+  if err != nil {
+    log.Printf("Cannot read architecture of %v: %v", ad.targetExePath, err)
+    return
+  }
+
+  searchDirs := append([]string{ ad.qtDeployer.qtEnv[QT_INSTALL_LIBS] }, ad.additionalLibPaths...)
+  var ldconfigCache map[string][]string
+
+  for _, library := range openSslLibraries {
+    libraryPath := ""
+
+    for _, soname := range versionedSonames(library, openSslSonameVersions(version)) {
+      candidates := findInDirs(soname, searchDirs)
+      if len(candidates) == 0 {
+        if ldconfigCache == nil { ldconfigCache = readLdconfigCache() }
+        candidates = ldconfigCache[soname]
+      }
+
+      for _, candidate := range candidates {
+        // ldconfig cache lists libraries of all installed architectures
+        if candidateMachine, err := elfMachine(candidate); err == nil && candidateMachine == machine {
+          libraryPath = candidate
+          break
+        }
+      }
+
+      if len(libraryPath) > 0 { break }
+    }
+
+    if len(libraryPath) == 0 {
+      log.Printf("Cannot find %v matching OpenSSL %v", library, strings.Join(version, "."))
+      continue
+    }
+
+    log.Printf("Deploying OpenSSL library %v", libraryPath)
+    ad.addLibTask("", libraryPath, "lib", LDD_AND_RPATH_FLAG)
+  }
+}
+
+func versionedSonames(library string, versions []string) []string {
+  sonames := make([]string, 0, len(versions))
+  for _, version := range versions {
+    sonames = append(sonames, library + "." + version)
+  }
+
+  return sonames
+}
+
+func findInDirs(filename string, dirs []string) []string {
+  found := make([]string, 0, 1)
+
+  for _, dir := range dirs {
+    if len(dir) == 0 { continue }
+
+    possiblePath := filepath.Join(dir, filename)
+    if _, err := os.Stat(possiblePath); err == nil {
+      found = append(found, possiblePath)
+    }
+  }
+
+  return found
+}
+
+// maps sonames to paths from "ldconfig -p" in order of preference
+func readLdconfigCache() map[string][]string {
+  out, err := exec.Command("/sbin/ldconfig", "-p").Output()
+  if err != nil {
+    log.Printf("Cannot read ldconfig cache: %v", err)
+    return make(map[string][]string)
+  }
+
+  return parseLdconfigOutput(string(out))
+}
+
+// lines look like "libssl.so.1.1 (libc6,x86-64) => /usr/lib/x86_64-linux-gnu/libssl.so.1.1"
+func parseLdconfigOutput(output string) map[string][]string {
+  cache := make(map[string][]string)
+
+  for _, line := range strings.Split(output, "\n") {
+    parts := strings.SplitN(strings.TrimSpace(line), " => ", 2)
+    if len(parts) != 2 { continue }
+
+    fields := strings.Fields(parts[0])
+    if len(fields) == 0 { continue }
+
+    cache[fields[0]] = append(cache[fields[0]], strings.TrimSpace(parts[1]))
+  }
+
+  return cache
+}
@@ -0,0 +1,122 @@
+/*
+ * This file is a part of linuxdeploy - tool for
+ * creating standalone applications for Linux
+ *
+ * Copyright (C) 2017 Taras Kushnir <kushnirTV@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the MIT License.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ */
+
+package main
+
+import (
+  "log"
+  "fmt"
+  "debug/elf"
+  "path/filepath"
+  "strings"
+  "os"
+  "image"
+  _ "image/png"
+)
+
+// packs deployed AppDir into the requested output format
+func (ad *AppDeployer) createOutput() error {
+  switch *outTypeFlag {
+  case "appimage": return ad.createAppImage()
+  case "tarball": return ad.createTarball()
+  case "dir": return ad.createPlainDir()
+  case "deb": return ad.createDebPackage()
+  case "rpm": return ad.createRpmPackage()
+  case "run": return ad.createSelfExtractingInstaller()
+  case "oci": return ad.createOciImage()
+  }
+
+  return fmt.Errorf("Unsupported output type %v", *outTypeFlag)
+}
+
+// deployed directory is the output itself, it only needs a launcher
+func (ad *AppDeployer) createPlainDir() error {
+  launcherPath := filepath.Join("bin", ad.appName())
+  if err := ad.generateLauncherScript(launcherPath); err != nil { return err }
+
+  log.Printf("Directory %v is ready, use %v to run the app", ad.destinationRoot, launcherPath)
+  return nil
+}
+
+func (ad *AppDeployer) appName() string {
+  return filepath.Base(ad.targetExePath)
+}
+
+// returns output path from the flag or default one in the parent of AppDir
+func (ad *AppDeployer) outputPath(flagValue, suffix string) string {
+  if len(flagValue) > 0 {
+    if path, err := filepath.Abs(flagValue); err == nil {
+      return path
+    }
+
+    return flagValue
+  }
+
+  basename := ad.appName()
+  if arch, err := elfArchitecture(ad.targetExePath); err == nil {
+    basename = fmt.Sprintf("%s-%s", basename, arch)
+  }
+
+  return filepath.Join(filepath.Dir(ad.destinationRoot), basename + suffix)
+}
+
+// path from -pkg-output or default filename next to the AppDir
+func (ad *AppDeployer) packageOutputPath(defaultFilename string) string {
+  if len(*packageOutputFlag) > 0 {
+    return ad.outputPath(*packageOutputFlag, "")
+  }
+
+  return filepath.Join(filepath.Dir(ad.destinationRoot), defaultFilename)
+}
+
+// architecture name as used by AppImage
+func elfArchitecture(path string) (string, error) {
+  machine, err := elfMachine(path)
+  if err != nil { return "", err }
+
+  switch machine {
+  case elf.EM_X86_64: return "x86_64", nil
+  case elf.EM_386: return "i686", nil
+  case elf.EM_AARCH64: return "aarch64", nil
+  case elf.EM_ARM: return "armhf", nil
+  }
+
+  return strings.ToLower(strings.TrimPrefix(machine.String(), "EM_")), nil
+}
+
+func elfMachine(path string) (elf.Machine, error) {
+  f, err := elf.Open(path)
+  if err != nil { return elf.EM_NONE, err }
+  defer f.Close()
+
+  log.Printf("Executable %v has machine %v", path, f.Machine)
+  return f.Machine, nil
+}
+
+// size directory of the hicolor icon theme for the icon
+func iconThemeSize(iconPath string) string {
+  if strings.ToLower(filepath.Ext(iconPath)) == ".svg" { return "scalable" }
+
+  f, err := os.Open(iconPath)
+  if err != nil { return "256x256" }
+  defer f.Close()
+
+  config, _, err := image.DecodeConfig(f)
+  if err != nil {
+    log.Printf("Cannot detect size of icon %v: %v", iconPath, err)
+    return "256x256"
+  }
+
+  return fmt.Sprintf("%dx%d", config.Width, config.Height)
+}
@@ -0,0 +1,89 @@
+/*
+ * This file is a part of linuxdeploy - tool for
+ * creating standalone applications for Linux
+ *
+ * Copyright (C) 2017 Taras Kushnir <kushnirTV@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the MIT License.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ */
+
+package main
+
+import (
+  "fmt"
+  "strings"
+  "path/filepath"
+)
+
+type pluginFilter struct {
+  include map[string]bool // if not empty only these plugins are deployed
+  exclude map[string]bool
+}
+
+// plugins directory to the filter of its plugins
+type PluginSelection map[string]*pluginFilter
+
+// parses specs like "sqldrivers=qsqlite" or "imageformats=-qwebp,-qtiff"
+func parsePluginSelection(specs []string) (PluginSelection, error) {
+  selection := make(PluginSelection)
+
+  for _, spec := range specs {
+    for _, part := range strings.Split(spec, ";") {
+      part = strings.TrimSpace(part)
+      if len(part) == 0 { continue }
+
+      keyValue := strings.SplitN(part, "=", 2)
+      if len(keyValue) != 2 || len(keyValue[0]) == 0 {
+        return nil, fmt.Errorf("Wrong Qt plugins selection %v. Expected format is dir=plugin,-plugin", part)
+      }
+
+      dir := strings.Trim(keyValue[0], "/")
+      filter, ok := selection[dir]
+      if !ok {
+        filter = &pluginFilter{ include: make(map[string]bool), exclude: make(map[string]bool) }
+        selection[dir] = filter
+      }
+
+      for _, name := range strings.Split(keyValue[1], ",") {
+        name = strings.TrimSpace(name)
+        if len(name) == 0 { continue }
+
+        if strings.HasPrefix(name, "-") {
+          filter.exclude[pluginName(name[1:])] = true
+        } else {
+          filter.include[pluginName(name)] = true
+        }
+      }
+    }
+  }
+
+  return selection, nil
+}
+
+// name of the plugin without prefix and extension: libqsqlite.so -> qsqlite
+func pluginName(filename string) string {
+  name := strings.TrimPrefix(filepath.Base(filename), "lib")
+  if index := strings.Index(name, ".so"); index != -1 {
+    name = name[:index]
+  }
+
+  return name
+}
+
+// checks plugin path relative to Qt plugins dir, e.g. sqldrivers/libqsqlmysql.so
+func (ps PluginSelection) isSelected(relativePath string) bool {
+  dir := strings.SplitN(filepath.ToSlash(relativePath), "/", 2)[0]
+  filter, ok := ps[dir]
+  if !ok { return true }
+
+  name := pluginName(relativePath)
+  if filter.exclude[name] { return false }
+  if len(filter.include) > 0 && !filter.include[name] { return false }
+
+  return true
+}
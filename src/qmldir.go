@@ -0,0 +1,164 @@
+/*
+ * This file is a part of linuxdeploy - tool for
+ * creating standalone applications for Linux
+ *
+ * Copyright (C) 2017 Taras Kushnir <kushnirTV@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the MIT License.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ */
+
+package main
+
+import (
+  "log"
+  "os"
+  "bufio"
+  "strings"
+  "path/filepath"
+)
+
+// contents of the qmldir file which matter for deployment
+type QmlModuleInfo struct {
+  name string
+  plugins []string // relative to the module dir
+  typeInfo []string
+  depends []qmlImportStatement
+  imports []qmlImportStatement
+  designerSupported bool
+}
+
+func parseQmldir(path string) (*QmlModuleInfo, error) {
+  f, err := os.Open(path)
+  if err != nil { return nil, err }
+  defer f.Close()
+
+  module := &QmlModuleInfo{}
+  scanner := bufio.NewScanner(f)
+
+  for scanner.Scan() {
+    fields := strings.Fields(scanner.Text())
+    if len(fields) == 0 || strings.HasPrefix(fields[0], "#") { continue }
+
+    // Qt 6 marks plugins which can be linked statically
+    if fields[0] == "optional" { fields = fields[1:] }
+    if len(fields) == 0 { continue }
+
+    switch fields[0] {
+    case "module":
+      if len(fields) > 1 { module.name = fields[1] }
+    case "plugin":
+      if len(fields) > 1 {
+        dir := ""
+        if len(fields) > 2 { dir = fields[2] }
+        module.plugins = append(module.plugins, filepath.Join(dir, "lib" + fields[1] + ".so"))
+      }
+    case "typeinfo":
+      if len(fields) > 1 { module.typeInfo = append(module.typeInfo, fields[1]) }
+    case "depends":
+      if statement, ok := qmldirImportStatement(fields); ok { module.depends = append(module.depends, statement) }
+    case "import":
+      if statement, ok := qmldirImportStatement(fields); ok { module.imports = append(module.imports, statement) }
+    case "designersupported":
+      module.designerSupported = true
+    }
+  }
+
+  return module, scanner.Err()
+}
+
+// "depends QtQuick 2.0" or "import QtQuick.Controls.impl auto"
+func qmldirImportStatement(fields []string) (qmlImportStatement, bool) {
+  if len(fields) < 2 { return qmlImportStatement{}, false }
+
+  statement := qmlImportStatement{ name: fields[1] }
+  if len(fields) > 2 && fields[2] != "auto" {
+    statement.version = fields[2]
+  }
+
+  return statement, true
+}
+
+// copies module files except nested modules and returns imports used by the module
+func (ad *AppDeployer) deployQmlModule(sourceRoot, relativePath string, module *QmlModuleInfo) []qmlImportStatement {
+  // rescue agains premature finish of the main loop
+  ad.waitGroup.Add(1)
+  defer ad.waitGroup.Done()
+
+  moduleRoot := filepath.Join(sourceRoot, relativePath)
+  plugins := make(map[string]bool)
+  for _, plugin := range module.plugins {
+    plugins[filepath.Join(moduleRoot, plugin)] = true
+  }
+
+  imports := append(append([]qmlImportStatement{}, module.depends...), module.imports...)
+  var emptyFlags Bitmask = 0
+
+  for plugin := range plugins {
+    // optional plugins can be linked into the module library statically
+    if _, err := os.Stat(plugin); err != nil {
+      log.Printf("Plugin %v of %v is not found", plugin, module.name)
+      continue
+    }
+
+    if pluginPath, err := filepath.Rel(sourceRoot, plugin); err == nil {
+      ad.addLibTask(sourceRoot, pluginPath, "qml", LDD_AND_RPATH_FLAG)
+    }
+  }
+
+  err := filepath.Walk(moduleRoot, func(path string, info os.FileInfo, err error) error {
+    if err != nil { return err }
+
+    if info.IsDir() {
+      if path == moduleRoot { return nil }
+
+      if *skipQmlDesignerFlag && info.Name() == "designer" {
+        log.Printf("Skipping designer dir %v", path)
+        return filepath.SkipDir
+      }
+
+      // nested modules are deployed only by their own import
+      if _, err := os.Stat(filepath.Join(path, "qmldir")); err == nil {
+        log.Printf("Skipping nested QML module dir %v", path)
+        return filepath.SkipDir
+      }
+
+      return nil
+    }
+
+    if !info.Mode().IsRegular() || plugins[path] { return nil }
+
+    if *skipQmlDesignerFlag && filepath.Ext(path) == ".qmltypes" { return nil }
+
+    basename := filepath.Base(path)
+
+    // libraries of the module are deployed only if qmldir lists them
+    if strings.HasPrefix(basename, "lib") && strings.Contains(basename, ".so") {
+      log.Printf("Skipping %v which is not a plugin of %v", path, module.name)
+      return nil
+    }
+
+    // imports from module files are not always listed in qmldir
+    if extension := filepath.Ext(path); extension == ".qml" || extension == ".js" {
+      if statements, err := parseQmlImportsFile(path, extension == ".js"); err == nil {
+        imports = append(imports, statements...)
+      }
+    }
+
+    filePath, err := filepath.Rel(sourceRoot, path)
+    if err != nil { return err }
+
+    ad.addCopyTask(sourceRoot, filePath, "qml", emptyFlags)
+    return nil
+  })
+
+  if err != nil {
+    log.Printf("Error while deploying QML module %v: %v", moduleRoot, err)
+  }
+
+  return imports
+}
@@ -0,0 +1,159 @@
+/*
+ * This file is a part of linuxdeploy - tool for
+ * creating standalone applications for Linux
+ *
+ * Copyright (C) 2017 Taras Kushnir <kushnirTV@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the MIT License.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ */
+
+package main
+
+import (
+  "log"
+  "os"
+  "bufio"
+  "strings"
+  "path/filepath"
+)
+
+// module import found in QML or JavaScript file
+type qmlImportStatement struct {
+  name string
+  version string
+}
+
+// replacement of qmlimportscanner which reports only module imports
+func scanQmlImports(rootDirs, importPaths []string) []QmlImport {
+  statements := make([]qmlImportStatement, 0, 10)
+  seen := make(map[qmlImportStatement]bool)
+
+  for _, rootDir := range rootDirs {
+    filepath.Walk(rootDir, func(path string, info os.FileInfo, err error) error {
+      if err != nil || !info.Mode().IsRegular() { return nil }
+
+      extension := filepath.Ext(path)
+      if extension != ".qml" && extension != ".js" { return nil }
+
+      fileStatements, err := parseQmlImportsFile(path, extension == ".js")
+      if err != nil {
+        log.Printf("Cannot scan %v for imports: %v", path, err)
+        return nil
+      }
+
+      for _, statement := range fileStatements {
+        if !seen[statement] {
+          seen[statement] = true
+          statements = append(statements, statement)
+        }
+      }
+
+      return nil
+    })
+  }
+
+  imports := make([]QmlImport, 0, len(statements))
+  for _, statement := range statements {
+    path := resolveQmlModule(statement.name, statement.version, importPaths)
+    if len(path) == 0 {
+      log.Printf("Cannot find QML module %v %v", statement.name, statement.version)
+      continue
+    }
+
+    imports = append(imports, QmlImport{
+      Name: statement.name,
+      Path: path,
+      ImportType: "module",
+      Version: statement.version,
+    })
+  }
+
+  return imports
+}
+
+func parseQmlImportsFile(path string, isJavaScript bool) ([]qmlImportStatement, error) {
+  f, err := os.Open(path)
+  if err != nil { return nil, err }
+  defer f.Close()
+
+  statements := make([]qmlImportStatement, 0, 5)
+  inComment := false
+  scanner := bufio.NewScanner(f)
+
+  for scanner.Scan() {
+    line := strings.TrimSpace(scanner.Text())
+
+    if inComment {
+      index := strings.Index(line, "*/")
+      if index == -1 { continue }
+      line = strings.TrimSpace(line[index + 2:])
+      inComment = false
+    }
+
+    if index := strings.Index(line, "//"); index != -1 { line = line[:index] }
+    if strings.HasPrefix(line, "/*") {
+      if !strings.Contains(line, "*/") { inComment = true }
+      continue
+    }
+
+    // imports are only allowed before the root object
+    if !isJavaScript && strings.Contains(line, "{") { break }
+
+    for _, part := range strings.Split(line, ";") {
+      if statement, ok := parseQmlImportStatement(part, isJavaScript); ok {
+        statements = append(statements, statement)
+      }
+    }
+  }
+
+  return statements, scanner.Err()
+}
+
+// parses "import QtQuick.Controls 2.15 as Controls" or ".import QtQuick.LocalStorage 2.0 as Sql" in JavaScript
+func parseQmlImportStatement(statement string, isJavaScript bool) (qmlImportStatement, bool) {
+  keyword := "import"
+  if isJavaScript { keyword = ".import" }
+
+  fields := strings.Fields(statement)
+  if len(fields) < 2 || fields[0] != keyword { return qmlImportStatement{}, false }
+
+  // quoted imports are directories and JavaScript files
+  if strings.HasPrefix(fields[1], "\"") { return qmlImportStatement{}, false }
+
+  result := qmlImportStatement{ name: fields[1] }
+  if len(fields) > 2 && fields[2] != "as" {
+    result.version = fields[2]
+  }
+
+  return result, true
+}
+
+// finds module dir the same way QML engine does: most specific version first
+func resolveQmlModule(name, version string, importPaths []string) string {
+  parts := strings.Split(name, ".")
+  candidates := make([]string, 0, 3)
+
+  versionParts := strings.Split(version, ".")
+  for i := len(versionParts); i > 0 && len(version) > 0; i-- {
+    versionSuffix := "." + strings.Join(versionParts[:i], ".")
+    candidates = append(candidates, filepath.Join(parts...) + versionSuffix)
+  }
+
+  candidates = append(candidates, filepath.Join(parts...))
+
+  for _, importPath := range importPaths {
+    for _, candidate := range candidates {
+      path := filepath.Join(importPath, candidate)
+      if _, err := os.Stat(filepath.Join(path, "qmldir")); err == nil {
+        return path
+      }
+    }
+  }
+
+  return ""
+}
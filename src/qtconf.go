@@ -0,0 +1,76 @@
+/*
+ * This file is a part of linuxdeploy - tool for
+ * creating standalone applications for Linux
+ *
+ * Copyright (C) 2017 Taras Kushnir <kushnirTV@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the MIT License.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ */
+
+package main
+
+import (
+  "log"
+  "os"
+  "fmt"
+  "bufio"
+  "path/filepath"
+)
+
+type qtConfPath struct {
+  key string
+  value string // relative to the Prefix
+}
+
+// matches the layout created in processQtLibTask
+var qtConfPaths = []qtConfPath {
+  { "Plugins", "plugins" },
+  { "Qml2Imports", "qml" },
+  { "Translations", "translations" },
+  { "Data", "." },
+  { "LibraryExecutables", "libexecs" },
+}
+
+// qt.conf is read by QtCore from the directory of the running executable
+func (ad *AppDeployer) generateQtConf() error {
+  exeDir := filepath.Dir(ad.destinationExePath)
+  prefix, err := filepath.Rel(exeDir, ad.destinationRoot)
+  if err != nil { return err }
+
+  if err = writeQtConf(exeDir, prefix); err != nil { return err }
+
+  // QtWebEngineProcess is a separate executable with its own application dir
+  libexecsPath := filepath.Join(ad.destinationRoot, "libexecs")
+  if _, err := os.Stat(filepath.Join(libexecsPath, "QtWebEngineProcess")); err == nil {
+    if err = writeQtConf(libexecsPath, ".."); err != nil { return err }
+  }
+
+  return nil
+}
+
+func writeQtConf(dir, prefix string) error {
+  confPath := filepath.Join(dir, "qt.conf")
+
+  f, err := os.OpenFile(confPath, os.O_CREATE | os.O_RDWR | os.O_TRUNC, 0644)
+  if err != nil { return err }
+  defer f.Close()
+
+  writer := bufio.NewWriter(f)
+
+  fmt.Fprintln(writer, "# generated by " + appName)
+  fmt.Fprintln(writer, "[Paths]")
+  fmt.Fprintf(writer, "Prefix = %s\n", filepath.ToSlash(prefix))
+  for _, path := range qtConfPaths {
+    fmt.Fprintf(writer, "%s = %s\n", path.key, path.value)
+  }
+
+  if err = writer.Flush(); err != nil { return err }
+
+  log.Printf("Generated %v", confPath)
+  return nil
+}
@@ -0,0 +1,210 @@
+/*
+ * This file is a part of linuxdeploy - tool for
+ * creating standalone applications for Linux
+ *
+ * Copyright (C) 2017 Taras Kushnir <kushnirTV@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the MIT License.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ */
+
+package main
+
+import (
+  "log"
+  "os"
+  "fmt"
+  "strings"
+  "io/ioutil"
+)
+
+type qtCorePatchRule struct {
+  key string // prefix of the value or whole string if exact
+  value string
+  exact bool
+  group string // rules of the same non-empty group are critical, one of them has to succeed
+}
+
+const (
+  QTCORE_PREFIX_GROUP = "prefix"
+  QTCORE_PLUGINS_GROUP = "plugins"
+)
+
+// this list originates from https://github.com/probonopd/linuxdeployqt
+var qtCorePatchRules = []qtCorePatchRule {
+  { "qt_prfxpath=", ".", false, QTCORE_PREFIX_GROUP },
+  { "qt_adatpath=", ".", false, "" },
+  { "qt_docspath=", "doc", false, "" },
+  { "qt_hdrspath=", "include", false, "" },
+  { "qt_libspath=", "lib", false, "" },
+  { "qt_lbexpath=", "libexec", false, "" },
+  { "qt_binspath=", "bin", false, "" },
+  { "qt_plugpath=", "plugins", false, QTCORE_PLUGINS_GROUP },
+  { "qt_impspath=", "imports", false, "" },
+  { "qt_qml2path=", "qml", false, "" },
+  { "qt_datapath=", ".", false, "" },
+  { "qt_trnspath=", "translations", false, "" },
+  { "qt_xmplpath=", "examples", false, "" },
+  { "qt_demopath=", "demos", false, "" },
+  { "qt_tstspath=", "tests", false, "" },
+  { "qt_hpfxpath=", ".", false, "" },
+  { "qt_hbinpath=", "bin", false, "" },
+  { "qt_hdatpath=", ".", false, "" },
+  { "qt_stngpath=", ".", false, "" }, // e.g., /opt/qt53/etc/xdg; does it load Trolltech.conf from there?
+
+  // newer Qt keeps only prefix as qt_prfxpath= and other paths relative to it
+  // Arch Linux: https://github.com/probonopd/linuxdeployqt/issues/98
+  { "lib/qt/libexec", "libexec", true, "" },
+  { "lib/qt/plugins", "plugins", true, QTCORE_PLUGINS_GROUP },
+  { "lib/qt/imports", "imports", true, "" },
+  { "lib/qt/qml", "qml", true, "" },
+  { "lib/qt", "", true, "" },
+  { "share/doc/qt", "doc", true, "" },
+  { "include/qt", "include", true, "" },
+  { "share/qt", "", true, "" },
+  { "share/qt/translations", "translations", true, "" },
+  { "share/doc/qt/examples", "examples", true, "" },
+
+  // Fedora
+  { "lib64/qt5/libexec", "libexec", true, "" },
+  { "lib64/qt5/plugins", "plugins", true, QTCORE_PLUGINS_GROUP },
+  { "lib64/qt5/imports", "imports", true, "" },
+  { "lib64/qt5/qml", "qml", true, "" },
+  { "lib64/qt5", "", true, "" },
+  { "share/doc/qt5", "doc", true, "" },
+  { "include/qt5", "include", true, "" },
+  { "share/qt5", "", true, "" },
+  { "share/qt5/translations", "translations", true, "" },
+  { "lib64/qt5/examples", "examples", true, "" },
+}
+
+type QtCorePatch struct {
+  Key string `json:"key"`
+  OldValue string `json:"old_value,omitempty"`
+  NewValue string `json:"new_value"`
+  Found bool `json:"found"`
+  Verified bool `json:"verified"`
+  Error string `json:"error,omitempty"`
+
+  offset int
+  group string
+}
+
+func (ad *AppDeployer) patchQtCore(libraryPath string) {
+  // rescue agains premature finish of the main loop
+  ad.waitGroup.Add(1)
+  defer ad.waitGroup.Done()
+
+  log.Printf("About to patch QtCore at path %v", libraryPath)
+  patches, err := patchQtCore(libraryPath)
+  ad.report.setQtCorePatches(patches)
+
+  if err != nil {
+    log.Printf("QtCore patching failed! %v", err)
+    ad.qtDeployer.qtCorePatchError = err
+    return
+  }
+
+  if missing := missingQtCoreGroups(patches); len(missing) > 0 {
+    log.Printf("Critical QtCore paths were not patched: %v. Falling back to qt.conf", strings.Join(missing, ", "))
+    ad.qtDeployer.qtConfRequired = true
+    return
+  }
+
+  log.Println("QtCore patching finished")
+}
+
+// patches paths, writes the file and verifies it by reading it again
+func patchQtCore(path string) ([]QtCorePatch, error) {
+  fi, err := os.Stat(path)
+  if err != nil { return nil, err }
+
+  originalMode := fi.Mode()
+
+  contents, err := ioutil.ReadFile(path)
+  if err != nil { return nil, err }
+
+  patches := applyQtCorePatches(contents)
+
+  if err = ioutil.WriteFile(path, contents, originalMode); err != nil { return patches, err }
+
+  written, err := ioutil.ReadFile(path)
+  if err != nil { return patches, err }
+
+  if failed := verifyQtCorePatches(written, patches); len(failed) > 0 {
+    return patches, fmt.Errorf("Patched values were not written for %v", strings.Join(failed, ", "))
+  }
+
+  return patches, nil
+}
+
+func applyQtCorePatches(contents []byte) []QtCorePatch {
+  patches := make([]QtCorePatch, 0, len(qtCorePatchRules))
+
+  for _, rule := range qtCorePatchRules {
+    patch := QtCorePatch{ Key: rule.key, NewValue: rule.value, group: rule.group }
+
+    var err error
+    if rule.exact {
+      patch.offset, patch.OldValue, err = replaceString(contents, rule.key, rule.value)
+    } else {
+      patch.offset, patch.OldValue, err = replaceVariable(contents, rule.key, rule.value)
+    }
+
+    // not found keys are expected since every Qt build has only some of them
+    patch.Found = patch.offset != -1
+    if err != nil && patch.Found {
+      patch.Error = err.Error()
+      log.Printf("Failed to patch %v: %v", rule.key, err)
+    }
+
+    if patch.Found || len(rule.group) > 0 {
+      patches = append(patches, patch)
+    }
+  }
+
+  return patches
+}
+
+// returns keys which have different value in the written file
+func verifyQtCorePatches(contents []byte, patches []QtCorePatch) []string {
+  failed := make([]string, 0)
+
+  for i := range patches {
+    patch := &patches[i]
+    if !patch.Found || len(patch.Error) > 0 { continue }
+
+    patch.Verified = readCString(contents, patch.offset) == patch.NewValue
+    if !patch.Verified {
+      failed = append(failed, patch.Key)
+    }
+  }
+
+  return failed
+}
+
+// critical groups which have none of the paths patched
+func missingQtCoreGroups(patches []QtCorePatch) []string {
+  patched := make(map[string]bool)
+  groups := make([]string, 0)
+
+  for _, patch := range patches {
+    if len(patch.group) == 0 { continue }
+    if _, ok := patched[patch.group]; !ok {
+      groups = append(groups, patch.group)
+      patched[patch.group] = false
+    }
+    if patch.Verified { patched[patch.group] = true }
+  }
+
+  missing := make([]string, 0)
+  for _, group := range groups {
+    if !patched[group] { missing = append(missing, group) }
+  }
+
+  return missing
+}
@@ -19,10 +19,10 @@ import (
   "strings"
   "os"
   "os/exec"
-  "io/ioutil"
   "errors"
   "path/filepath"
   "encoding/json"
+  "time"
 )
 
 type QMakeKey int
@@ -55,64 +55,121 @@ const (
 type QtDeployer struct {
   qmakePath string
   qmakeVars map[string]string
-  deployedQmlImports map[string]bool
+  deployedQmlImports *qmlImportNode
   qtEnv map[QMakeKey]string
   qmlImportDirs []string
-  privateWidgetsDeployed bool
   qtEnvironmentSet bool
+  qtCoreDeployed bool
+  pluginRules []QtPluginRule
+  qtConfRequired bool // QtCore patching was not enough
+  qtCorePatchError error
   translationsRequired map[string]bool
 }
 
+var qmakeKeyNames = map[QMakeKey]string {
+  QT_INSTALL_PREFIX: "QT_INSTALL_PREFIX",
+  QT_INSTALL_ARCHDATA: "QT_INSTALL_ARCHDATA",
+  QT_INSTALL_DATA: "QT_INSTALL_DATA",
+  QT_INSTALL_DOCS: "QT_INSTALL_DOCS",
+  QT_INSTALL_HEADERS: "QT_INSTALL_HEADERS",
+  QT_INSTALL_LIBS: "QT_INSTALL_LIBS",
+  QT_INSTALL_LIBEXECS: "QT_INSTALL_LIBEXECS",
+  QT_INSTALL_BINS: "QT_INSTALL_BINS",
+  QT_INSTALL_TESTS: "QT_INSTALL_TESTS",
+  QT_INSTALL_PLUGINS: "QT_INSTALL_PLUGINS",
+  QT_INSTALL_IMPORTS: "QT_INSTALL_IMPORTS",
+  QT_INSTALL_QML: "QT_INSTALL_QML",
+  QT_INSTALL_TRANSLATIONS: "QT_INSTALL_TRANSLATIONS",
+  QT_INSTALL_CONFIGURATION: "QT_INSTALL_CONFIGURATION",
+  QT_INSTALL_EXAMPLES: "QT_INSTALL_EXAMPLES",
+  QT_INSTALL_DEMOS: "QT_INSTALL_DEMOS",
+  QT_HOST_PREFIX: "QT_HOST_PREFIX",
+  QT_HOST_DATA: "QT_HOST_DATA",
+  QT_HOST_BINS: "QT_HOST_BINS",
+  QT_HOST_LIBS: "QT_HOST_LIBS",
+  QMAKE_VERSION: "QMAKE_VERSION",
+  QT_VERSION: "QT_VERSION",
+}
+
+// variants of the values in the order of preference:
+// /get is the effective value for relocated installs, /raw is without sysroot
+// and /src points to the sources of not installed Qt build
+var qmakeKeyVariants = []string { "/get", "", "/raw", "/src" }
+
 func (qd *QtDeployer) queryQtEnv() error {
   log.Printf("Querying qmake environment using %v", qd.qmakePath)
   if len(qd.qmakePath) == 0 { return errors.New("QMake has not been resolved") }
 
-  out, err := exec.Command(qd.qmakePath, "-query").Output()
+  out, err := queryQtPaths(qd.qmakePath)
   if err != nil { return err }
 
-  output := string(out)
-  // TODO: probably switch to bytes.Split for better performance
-  lines := strings.Split(output, "\n")
+  qd.qmakeVars = parseQMakeOutput(string(out))
+
+  qd.parseQtVars()
+  log.Printf("Parsed qmake output: %v", qd.qtEnv)
+  qd.qtEnvironmentSet = true
+  return nil
+}
+
+// prefers qtpaths next to qmake since it knows about relocated installs
+func queryQtPaths(qmakePath string) ([]byte, error) {
+  if strings.HasPrefix(filepath.Base(qmakePath), "qtpaths") {
+    return exec.Command(qmakePath, "--query").Output()
+  }
+
+  qmakeDir := filepath.Dir(qmakePath)
+  for _, qtpaths := range []string { "qtpaths6", "qtpaths" } {
+    qtpathsPath := filepath.Join(qmakeDir, qtpaths)
+    if _, err := os.Stat(qtpathsPath); err != nil { continue }
 
-  for _, line := range lines {
+    // qtpaths from Qt 5 does not support --query
+    out, err := exec.Command(qtpathsPath, "--query").Output()
+    if err == nil && len(out) > 0 {
+      log.Printf("Using %v instead of qmake", qtpathsPath)
+      return out, nil
+    }
+
+    log.Printf("Cannot query %v: %v", qtpathsPath, err)
+  }
+
+  return exec.Command(qmakePath, "-query").Output()
+}
+
+func parseQMakeOutput(output string) map[string]string {
+  vars := make(map[string]string)
+
+  for _, line := range strings.Split(output, "\n") {
     line = strings.TrimSpace(line)
     if len(line) == 0 { continue }
-    parts := strings.Split(line, ":")
+
+    // values can contain colons too
+    parts := strings.SplitN(line, ":", 2)
 
     if len(parts) != 2 {
       log.Printf("Unexpected qmake output: %v", line)
       continue
     }
 
-    qd.qmakeVars[parts[0]] = parts[1]
+    vars[parts[0]] = parts[1]
   }
 
-  qd.parseQtVars()
-  log.Println("Parsed qmake output: %v", qd.qtEnv)
-  qd.qtEnvironmentSet = true
-  return nil
+  return vars
+}
+
+func (qd *QtDeployer) qmakeValue(name string) string {
+  for _, variant := range qmakeKeyVariants {
+    if value, ok := qd.qmakeVars[name + variant]; ok && len(value) > 0 {
+      return value
+    }
+  }
+
+  return ""
 }
 
 func (qd *QtDeployer) parseQtVars() {
-  qd.qtEnv[QT_INSTALL_PREFIX], _ = qd.qmakeVars["QT_INSTALL_PREFIX"]
-  qd.qtEnv[QT_INSTALL_ARCHDATA], _ = qd.qmakeVars["QT_INSTALL_ARCHDATA"]
-  qd.qtEnv[QT_INSTALL_DATA], _ = qd.qmakeVars["QT_INSTALL_DATA"]
-  qd.qtEnv[QT_INSTALL_DOCS], _ = qd.qmakeVars["QT_INSTALL_DOCS"]
-  qd.qtEnv[QT_INSTALL_HEADERS], _ = qd.qmakeVars["QT_INSTALL_HEADERS"]
-  qd.qtEnv[QT_INSTALL_LIBS], _ = qd.qmakeVars["QT_INSTALL_LIBS"]
-  qd.qtEnv[QT_INSTALL_LIBEXECS], _ = qd.qmakeVars["QT_INSTALL_LIBEXECS"]
-  qd.qtEnv[QT_INSTALL_BINS], _ = qd.qmakeVars["QT_INSTALL_BINS"]
-  qd.qtEnv[QT_INSTALL_PLUGINS], _ = qd.qmakeVars["QT_INSTALL_PLUGINS"]
-  qd.qtEnv[QT_INSTALL_IMPORTS], _ = qd.qmakeVars["QT_INSTALL_IMPORTS"]
-  qd.qtEnv[QT_INSTALL_QML], _ = qd.qmakeVars["QT_INSTALL_QML"]
-  qd.qtEnv[QT_INSTALL_TRANSLATIONS], _ = qd.qmakeVars["QT_INSTALL_TRANSLATIONS"]
-  qd.qtEnv[QT_INSTALL_CONFIGURATION], _ = qd.qmakeVars["QT_INSTALL_CONFIGURATION"]
-  qd.qtEnv[QT_HOST_PREFIX], _ = qd.qmakeVars["QT_HOST_PREFIX"]
-  qd.qtEnv[QT_HOST_DATA], _ = qd.qmakeVars["QT_HOST_DATA"]
-  qd.qtEnv[QT_HOST_BINS], _ = qd.qmakeVars["QT_HOST_BINS"]
-  qd.qtEnv[QT_HOST_LIBS], _ = qd.qmakeVars["QT_HOST_LIBS"]
-  qd.qtEnv[QMAKE_VERSION], _ = qd.qmakeVars["QMAKE_VERSION"]
-  qd.qtEnv[QT_VERSION], _ = qd.qmakeVars["QT_VERSION"]
+  for key, name := range qmakeKeyNames {
+    qd.qtEnv[key] = qd.qmakeValue(name)
+  }
 }
 
 func (qd *QtDeployer) BinPath() string {
@@ -139,14 +196,66 @@ func (qd *QtDeployer) QmlPath() string {
   return qd.qtEnv[QT_INSTALL_QML]
 }
 
-func (qd *QtDeployer) accountQmlImport(path string) {
-  qd.deployedQmlImports[path] = true
+// Qt QML dir and additional dirs from -qmldir
+func (qd *QtDeployer) qmlImportPaths() []string {
+  return append([]string{ qd.QmlPath() }, qd.qmlImportDirs...)
+}
+
+// returns the import path which contains the module dir
+func (qd *QtDeployer) qmlImportRoot(path string) (string, bool) {
+  for _, importPath := range qd.qmlImportPaths() {
+    if isSubpath(path, importPath) { return importPath, true }
+  }
+
+  return "", false
+}
+
+// prefix tree of deployed QML module dirs by path components
+type qmlImportNode struct {
+  children map[string]*qmlImportNode
+  name string // import deployed from this dir
+  deployed bool
+  recursive bool // nested modules were copied together with this one
 }
 
-func (qd *QtDeployer) isQmlImportDeployed(path string) (deployed bool) {
-  // TODO: also check directory hierarchy?
-  _, deployed = qd.deployedQmlImports[path]
-  return deployed
+func newQmlImportNode() *qmlImportNode {
+  return &qmlImportNode{ children: make(map[string]*qmlImportNode) }
+}
+
+func qmlImportPathComponents(path string) []string {
+  return strings.Split(filepath.ToSlash(filepath.Clean(path)), "/")
+}
+
+func (qd *QtDeployer) accountQmlImport(path, name string, recursive bool) {
+  node := qd.deployedQmlImports
+  for _, component := range qmlImportPathComponents(path) {
+    child, ok := node.children[component]
+    if !ok {
+      child = newQmlImportNode()
+      node.children[component] = child
+    }
+    node = child
+  }
+
+  node.deployed = true
+  node.name = name
+  node.recursive = recursive
+}
+
+// returns the import which deployed the path itself or one of its parents with all nested dirs
+func (qd *QtDeployer) findDeployedQmlImport(path string) (parentPath, parentName string, deployed bool) {
+  node := qd.deployedQmlImports
+  components := qmlImportPathComponents(path)
+
+  for i, component := range components {
+    if node = node.children[component]; node == nil { return "", "", false }
+
+    if node.deployed && (node.recursive || i == len(components) - 1) {
+      return filepath.FromSlash(strings.Join(components[:i + 1], "/")), node.name, true
+    }
+  }
+
+  return "", "", false
 }
 
 func (ad *AppDeployer) processQtLibTasks() {
@@ -158,7 +267,9 @@ func (ad *AppDeployer) processQtLibTasks() {
   go ad.deployQmlImports()
 
   for libraryPath := range ad.qtChannel {
+    start := time.Now()
     ad.processQtLibTask(libraryPath)
+    ad.report.addStageTime(STAGE_QT, start)
     // rpath should be changed for all qt libs
     ad.addFixRPathTask(libraryPath)
 
@@ -177,42 +288,45 @@ func (ad *AppDeployer) processQtLibTask(libraryPath string) {
 
   ad.qtDeployer.accountQtLibrary(libname)
 
-  deployFlags := LDD_DEPENDENCY_FLAG | DEPLOY_ONLY_LIBRARIES_FLAG | FIX_RPATH_FLAG
-
-  if strings.HasPrefix(libname, "libqt5gui") {
-    ad.addQtPluginTask("platforms/libqxcb.so")
-    ad.deployRecursively(ad.qtDeployer.PluginsPath(), "imageformats", "plugins", deployFlags)
-  } else
-  if strings.HasPrefix(libname, "libqt5svg") {
-    ad.addQtPluginTask("iconengines/libqsvgicon.so")
-  } else
-  if strings.HasPrefix(libname, "libqt5printsupport") {
-    ad.addQtPluginTask("printsupport/libcupsprintersupport.so")
-  } else
-  if strings.HasPrefix(libname, "libqt5opengl") ||
-    strings.HasPrefix(libname, "libqt5xcbqpa") {
-    ad.deployRecursively(ad.qtDeployer.PluginsPath(), "xcbglintegrations", "plugins", deployFlags)
-  } else
-  if strings.HasPrefix(libname, "libqt5network") {
-    ad.deployRecursively(ad.qtDeployer.PluginsPath(), "bearer", "plugins", deployFlags)
-  } else
-  if strings.HasPrefix(libname, "libqt5sql") {
-    ad.deployRecursively(ad.qtDeployer.PluginsPath(), "sqldrivers", "plugins", deployFlags)
-  } else
-  if strings.HasPrefix(libname, "libqt5multimedia") {
-    ad.deployRecursively(ad.qtDeployer.PluginsPath(), "mediaservice", "plugins", deployFlags)
-    ad.deployRecursively(ad.qtDeployer.PluginsPath(), "audio", "plugins", deployFlags)
-  } else
-  if strings.HasPrefix(libname, "libqt5webenginecore") {
-    ad.addCopyQtDepTask(ad.qtDeployer.LibExecsPath(), "QtWebEngineProcess", "libexecs")
-    ad.copyRecursively(ad.qtDeployer.DataPath(), "resources", ".")
-    ad.copyRecursively(ad.qtDeployer.TranslationsPath(), "qtwebengine_locales", "translations")
-  } else
-  if strings.HasPrefix(libname, "libqt5core") {
-    ad.patchQtCore(libraryPath)
+  module := qtModuleName(libname)
+  qtVersion := 5
+  if strings.HasPrefix(libname, "libqt6") { qtVersion = 6 }
+
+  ad.deployQtPluginRules(module, qtVersion)
+
+  // QtNetwork loads OpenSSL with dlopen() so ldd does not see it
+  if module == "network" && !(*noOpenSslFlag) {
+    ad.deployOpenSsl(libraryPath, qtVersion)
+  }
+
+  if module == "core" {
+    ad.qtDeployer.qtCoreDeployed = true
+
+    if qtVersion >= 6 {
+      // Qt 6 has no qt_prfxpath= and similar strings to patch
+      log.Printf("Using qt.conf instead of patching %v", libraryBasename)
+      ad.qtDeployer.qtConfRequired = true
+      // qt.conf makes patching unnecessary unless explicitly asked for
+    } else if !(*qtConfFlag) || *patchQtCoreFlag {
+      ad.patchQtCore(libraryPath)
+    }
   }
 }
 
+// strips lib prefix, Qt major version and extension: libqt6gui.so.6 -> gui
+func qtModuleName(libname string) string {
+  module := strings.TrimPrefix(strings.TrimPrefix(strings.ToLower(libname), "lib"), "qt")
+  if strings.HasPrefix(module, "5") || strings.HasPrefix(module, "6") {
+    module = module[1:]
+  }
+
+  if index := strings.Index(module, ".so"); index != -1 {
+    module = module[:index]
+  }
+
+  return module
+}
+
 // copies one file
 func (ad *AppDeployer) addCopyQtDepTask(sourceRoot, sourcePath, targetPath string) error {
   path := filepath.Join(sourceRoot, sourcePath)
@@ -236,6 +350,11 @@ func (ad *AppDeployer) addCopyQtDepTask(sourceRoot, sourcePath, targetPath strin
 }
 
 func (ad *AppDeployer) addQtPluginTask(relpath string) {
+  if !qtPluginSelection.isSelected(relpath) {
+    log.Printf("Skipping Qt plugin %v due to -qt-plugins selection", relpath)
+    return
+  }
+
   log.Printf("Deploying additional Qt plugin: %v", relpath)
   ad.addLibTask(ad.qtDeployer.PluginsPath(), relpath, "plugins", LDD_AND_RPATH_FLAG)
 }
@@ -251,8 +370,9 @@ func (ad *AppDeployer) deployQmlImports() error {
 
   if _, err := os.Stat(scannerPath); err != nil {
     if scannerPath, err = exec.LookPath("qmlimportscanner"); err != nil {
-      log.Printf("Cannot find qmlimportscanner")
-      return err
+      // distributions often ship qmlimportscanner in a separate package
+      log.Printf("Cannot find qmlimportscanner, using built-in scanner")
+      return ad.processQmlImports(scanQmlImports(ad.qtDeployer.qmlImportDirs, ad.qtDeployer.qmlImportPaths()))
     }
   }
 
@@ -264,8 +384,10 @@ func (ad *AppDeployer) deployQmlImports() error {
     args = append(args, qmldir)
   }
 
-  args = append(args, "-importPath")
-  args = append(args, ad.qtDeployer.QmlPath())
+  for _, importPath := range ad.qtDeployer.qmlImportPaths() {
+    args = append(args, "-importPath")
+    args = append(args, importPath)
+  }
 
   out, err := exec.Command(scannerPath, args...).Output()
   if err != nil {
@@ -294,12 +416,18 @@ func (ad *AppDeployer) processQmlImportsJson(jsonRaw []byte) (err error) {
   if err != nil { return err }
   log.Printf("Parsed %v imports", len(qmlImports))
 
-  sourceRoot := ad.qtDeployer.QmlPath()
+  return ad.processQmlImports(qmlImports)
+}
 
-  for _, qmlImport := range qmlImports {
-    relativePath, err := filepath.Rel(sourceRoot, qmlImport.Path)
+func (ad *AppDeployer) processQmlImports(qmlImports []QmlImport) error {
+  importPaths := ad.qtDeployer.qmlImportPaths()
+  queued := make(map[string]bool)
+
+  // dependencies of deployed modules are appended while processing
+  for i := 0; i < len(qmlImports); i++ {
+    qmlImport := qmlImports[i]
 
-    if err != nil || len(qmlImport.Name) == 0 {
+    if len(qmlImport.Name) == 0 {
       log.Printf("Skipping import %v", qmlImport)
       continue
     }
@@ -314,83 +442,54 @@ func (ad *AppDeployer) processQmlImportsJson(jsonRaw []byte) (err error) {
       continue
     }
 
-    if ad.qtDeployer.isQmlImportDeployed(qmlImport.Path) {
-      log.Printf("Skipping already deployed QML import %v", qmlImport.Path)
+    // modules of the app itself and paths like "../" are not deployed as imports
+    sourceRoot, ok := ad.qtDeployer.qmlImportRoot(qmlImport.Path)
+    if !ok {
+      log.Printf("Skipping import outside of QML import paths %v", qmlImport.Path)
       continue
     }
 
-    if (qmlImport.Name == "QtQuick.Controls") && !ad.qtDeployer.privateWidgetsDeployed {
-      ad.qtDeployer.privateWidgetsDeployed = true
-      log.Printf("Deploying private widgets for QtQuick.Controls")
-      ad.deployRecursively(sourceRoot, "QtQuick/PrivateWidgets", "qml", FIX_RPATH_FLAG)
+    relativePath, err := filepath.Rel(sourceRoot, qmlImport.Path)
+    if err != nil || relativePath == "." {
+      log.Printf("Skipping import %v", qmlImport)
+      continue
+    }
+
+    if parentPath, parentName, deployed := ad.qtDeployer.findDeployedQmlImport(qmlImport.Path); deployed {
+      if parentPath == filepath.Clean(qmlImport.Path) {
+        log.Printf("Skipping already deployed QML import %v", qmlImport.Path)
+      } else {
+        log.Printf("Skipping QML import %v covered by %v from %v", qmlImport.Name, parentName, parentPath)
+      }
+      continue
     }
 
     log.Printf("Deploying QML import %v", qmlImport.Path)
-    ad.qtDeployer.accountQmlImport(qmlImport.Path)
-    ad.deployRecursively(sourceRoot, relativePath, "qml", FIX_RPATH_FLAG)
-  }
+    ad.report.accountQmlModule()
 
-  return nil
-}
+    module, err := parseQmldir(filepath.Join(qmlImport.Path, "qmldir"))
+    ad.qtDeployer.accountQmlImport(qmlImport.Path, qmlImport.Name, err != nil)
 
-func (ad *AppDeployer) patchQtCore(libraryPath string) {
-  // rescue agains premature finish of the main loop
-  ad.waitGroup.Add(1)
-  defer ad.waitGroup.Done()
+    if err != nil {
+      log.Printf("Cannot parse qmldir of %v: %v", qmlImport.Path, err)
+      ad.deployRecursively(sourceRoot, relativePath, "qml", FIX_RPATH_FLAG)
+      continue
+    }
 
-  log.Printf("About to patch libQt5Core at path %v", libraryPath)
-  err := patchQtCore(libraryPath)
-  if err != nil {
-    log.Printf("QtCore patching failed! %v", err)
-  } else {
-    log.Println("QtCore patching finished")
+    for _, statement := range ad.deployQmlModule(sourceRoot, relativePath, module) {
+      path := resolveQmlModule(statement.name, statement.version, importPaths)
+      if len(path) == 0 || queued[path] { continue }
+
+      log.Printf("QML import %v requires %v", qmlImport.Name, statement.name)
+      queued[path] = true
+      qmlImports = append(qmlImports, QmlImport{
+        Name: statement.name,
+        Path: path,
+        ImportType: "module",
+        Version: statement.version,
+      })
+    }
   }
-}
-
-func patchQtCore(path string) error {
-  fi, err := os.Stat(path)
-  if err != nil { return err }
 
-  originalMode := fi.Mode()
-
-  contents, err := ioutil.ReadFile(path)
-  if err != nil { return err }
-
-  // this list originates from https://github.com/probonopd/linuxdeployqt
-  replaceVariable(contents, "qt_prfxpath=", ".");
-  replaceVariable(contents, "qt_adatpath=", ".");
-  replaceVariable(contents, "qt_docspath=", "doc");
-  replaceVariable(contents, "qt_hdrspath=", "include");
-  replaceVariable(contents, "qt_libspath=", "lib");
-  replaceVariable(contents, "qt_lbexpath=", "libexec");
-  replaceVariable(contents, "qt_binspath=", "bin");
-  replaceVariable(contents, "qt_plugpath=", "plugins");
-  replaceVariable(contents, "qt_impspath=", "imports");
-  replaceVariable(contents, "qt_qml2path=", "qml");
-  replaceVariable(contents, "qt_datapath=", ".");
-  replaceVariable(contents, "qt_trnspath=", "translations");
-  replaceVariable(contents, "qt_xmplpath=", "examples");
-  replaceVariable(contents, "qt_demopath=", "demos");
-  replaceVariable(contents, "qt_tstspath=", "tests");
-  replaceVariable(contents, "qt_hpfxpath=", ".");
-  replaceVariable(contents, "qt_hbinpath=", "bin");
-  replaceVariable(contents, "qt_hdatpath=", ".");
-  replaceVariable(contents, "qt_stngpath=", "."); // e.g., /opt/qt53/etc/xdg; does it load Trolltech.conf from there?
-
-  /* Qt on Arch Linux comes with more hardcoded paths
-  * https://github.com/probonopd/linuxdeployqt/issues/98
-  replaceVariable(contents, "lib/qt/libexec", "libexec");
-  replaceVariable(contents, "lib/qt/plugins", "plugins");
-  replaceVariable(contents, "lib/qt/imports", "imports");
-  replaceVariable(contents, "lib/qt/qml", "qml");
-  replaceVariable(contents, "lib/qt", "");
-  replaceVariable(contents, "share/doc/qt", "doc");
-  replaceVariable(contents, "include/qt", "include");
-  replaceVariable(contents, "share/qt", "");
-  replaceVariable(contents, "share/qt/translations", "translations");
-  replaceVariable(contents, "share/doc/qt/examples", "examples");
-  */
-
-  err = ioutil.WriteFile(path, contents, originalMode)
-  return err
+  return nil
 }
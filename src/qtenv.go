@@ -0,0 +1,144 @@
+/*
+ * This file is a part of linuxdeploy - tool for
+ * creating standalone applications for Linux
+ *
+ * Copyright (C) 2017 Taras Kushnir <kushnirTV@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the MIT License.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ */
+
+package main
+
+import (
+  "log"
+  "os"
+  "fmt"
+  "bytes"
+  "strings"
+  "io/ioutil"
+  "path/filepath"
+)
+
+// tools inside of Qt installation which can print Qt environment
+var qtInstallTools = []string { "qtpaths6", "qmake6", "qmake", "qtpaths" }
+
+// finds QtCore the exe is linked with so Qt environment is taken from the same Qt
+func (ad *AppDeployer) findLinkedQtCore() string {
+  dependencies, err := ad.findLddDependencies(filepath.Base(ad.targetExePath), ad.targetExePath)
+  if err != nil {
+    log.Printf("Cannot inspect dependencies of %v: %v", ad.targetExePath, err)
+    return ""
+  }
+
+  for _, dependency := range dependencies {
+    libname := strings.ToLower(filepath.Base(dependency))
+    if strings.HasPrefix(libname, "libqt5core.so") || strings.HasPrefix(libname, "libqt6core.so") {
+      if resolved, err := filepath.EvalSymlinks(dependency); err == nil {
+        return resolved
+      }
+      return dependency
+    }
+  }
+
+  return ""
+}
+
+// looks for qmake or qtpaths in the installation of the given QtCore
+func findQMakeForQtCore(qtCorePath string) string {
+  libDir := filepath.Dir(qtCorePath)
+  binDirs := []string {
+    filepath.Join(filepath.Dir(libDir), "bin"),
+    // distributions keep Qt tools in lib dir, e.g. /usr/lib/x86_64-linux-gnu/qt5/bin
+    filepath.Join(libDir, "qt6", "bin"),
+    filepath.Join(libDir, "qt5", "bin"),
+  }
+
+  if prefix, err := readQtCorePrefix(qtCorePath); err == nil && len(prefix) > 0 {
+    log.Printf("QtCore %v has prefix %v", qtCorePath, prefix)
+    binDirs = append(binDirs, filepath.Join(prefix, "bin"))
+  }
+
+  for _, binDir := range binDirs {
+    for _, tool := range qtInstallTools {
+      toolPath := filepath.Join(binDir, tool)
+      if info, err := os.Stat(toolPath); err == nil && !info.IsDir() {
+        return toolPath
+      }
+    }
+  }
+
+  return ""
+}
+
+// reads prefix embedded into QtCore by Qt build
+func readQtCorePrefix(qtCorePath string) (string, error) {
+  contents, err := ioutil.ReadFile(qtCorePath)
+  if err != nil { return "", err }
+
+  key := []byte("qt_prfxpath=")
+  index := bytes.Index(contents, key)
+  if index == -1 { return "", fmt.Errorf("Prefix is not found in %v", qtCorePath) }
+
+  return readCString(contents, index + len(key)), nil
+}
+
+// version from the name of resolved library, e.g. libQt5Core.so.5.9.1 -> 5.9.1
+func qtLibraryVersion(libraryPath string) string {
+  basename := filepath.Base(libraryPath)
+  index := strings.Index(basename, ".so.")
+  if index == -1 { return "" }
+
+  return basename[index + len(".so."):]
+}
+
+// checks that versions are equal in all the components library version has
+func qtVersionsMatch(qmakeVersion, libraryVersion string) bool {
+  qmakeParts := strings.Split(qmakeVersion, ".")
+  libraryParts := strings.Split(libraryVersion, ".")
+
+  if len(libraryParts) > len(qmakeParts) { return false }
+
+  for i, part := range libraryParts {
+    if qmakeParts[i] != part { return false }
+  }
+
+  return true
+}
+
+// queries Qt environment and returns error only if it does not match linked Qt
+func (ad *AppDeployer) resolveQtEnvironment() error {
+  qtCorePath := ad.findLinkedQtCore()
+  if len(qtCorePath) > 0 {
+    log.Printf("Executable is linked with %v", qtCorePath)
+  }
+
+  if len(qtCorePath) > 0 && len(*qmakePathFlag) == 0 {
+    if qmakePath := findQMakeForQtCore(qtCorePath); len(qmakePath) > 0 {
+      log.Printf("Using %v from the linked Qt installation", qmakePath)
+      ad.qtDeployer.qmakePath = qmakePath
+    } else {
+      log.Printf("Cannot find qmake for %v, using %v", qtCorePath, ad.qtDeployer.qmakePath)
+    }
+  }
+
+  if err := ad.qtDeployer.queryQtEnv(); err != nil {
+    log.Println(err)
+    return nil
+  }
+
+  libraryVersion := qtLibraryVersion(qtCorePath)
+  qmakeVersion := ad.qtDeployer.qtEnv[QT_VERSION]
+  if len(libraryVersion) == 0 || len(qmakeVersion) == 0 { return nil }
+
+  if !qtVersionsMatch(qmakeVersion, libraryVersion) {
+    return fmt.Errorf("Qt version %v from %v differs from version %v of linked %v. Please specify matching qmake with -qmake",
+      qmakeVersion, ad.qtDeployer.qmakePath, libraryVersion, qtCorePath)
+  }
+
+  return nil
+}
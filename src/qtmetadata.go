@@ -0,0 +1,451 @@
+/*
+ * This file is a part of linuxdeploy - tool for
+ * creating standalone applications for Linux
+ *
+ * Copyright (C) 2017 Taras Kushnir <kushnirTV@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the MIT License.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ */
+
+package main
+
+import (
+  "fmt"
+  "bytes"
+  "errors"
+  "math"
+  "strings"
+  "strconv"
+  "debug/elf"
+  "encoding/binary"
+  "unicode/utf16"
+)
+
+// metadata which moc embeds into every Qt plugin
+type QtPluginMetadata struct {
+  Path string `json:"path"`
+  IID string `json:"iid"`
+  ClassName string `json:"class_name,omitempty"`
+  Keys []string `json:"keys,omitempty"`
+  QtVersion string `json:"qt_version,omitempty"`
+  VersionMismatch bool `json:"version_mismatch,omitempty"`
+}
+
+const (
+  qtMetadataSection = ".qtmetadata"
+  qtMetadataNoteSection = ".note.qt.metadata"
+  qtMetadataNoteName = "qt-project!"
+)
+
+var qtMetadataMagic = []byte("QTMETADATA")
+
+// keys of CBOR metadata map used since Qt 5.13
+const (
+  QT_METADATA_QT_VERSION = iota
+  QT_METADATA_REQUIREMENTS
+  QT_METADATA_IID
+  QT_METADATA_CLASSNAME
+  QT_METADATA_METADATA
+  QT_METADATA_URI
+  QT_METADATA_IS_DEBUG
+)
+
+func readQtPluginMetadata(path string) (*QtPluginMetadata, error) {
+  f, err := elf.Open(path)
+  if err != nil { return nil, err }
+  defer f.Close()
+
+  if section := f.Section(qtMetadataSection); section != nil {
+    data, err := section.Data()
+    if err != nil { return nil, err }
+    return parseQtMetadataSection(data)
+  }
+
+  // newer Qt 6 puts metadata into ELF note
+  if section := f.Section(qtMetadataNoteSection); section != nil {
+    data, err := section.Data()
+    if err != nil { return nil, err }
+
+    desc, err := findElfNote(data, qtMetadataNoteName, f.ByteOrder)
+    if err != nil { return nil, err }
+    return parseQtCborMetadata(desc)
+  }
+
+  return nil, fmt.Errorf("No Qt metadata found in %v", path)
+}
+
+// checks if plugin handles any of the image formats declared by the app
+func (md *QtPluginMetadata) supportsAnyFormat(formats map[string]bool) bool {
+  for _, key := range md.Keys {
+    if formats[strings.ToLower(key)] { return true }
+  }
+
+  return false
+}
+
+// plugins built with different major or newer minor Qt version do not load
+func qtPluginVersionMismatch(pluginVersion, qtVersion string) bool {
+  pluginParts := strings.Split(pluginVersion, ".")
+  qtParts := strings.Split(qtVersion, ".")
+  if len(pluginParts) < 2 || len(qtParts) < 2 { return false }
+
+  if pluginParts[0] != qtParts[0] { return true }
+
+  pluginMinor, err := strconv.Atoi(pluginParts[1])
+  if err != nil { return false }
+  qtMinor, err := strconv.Atoi(qtParts[1])
+  if err != nil { return false }
+
+  return pluginMinor > qtMinor
+}
+
+func parseQtMetadataSection(data []byte) (*QtPluginMetadata, error) {
+  index := bytes.Index(data, qtMetadataMagic)
+  if index == -1 { return nil, errors.New("Qt metadata magic is not found") }
+
+  // magic is "QTMETADATA  " for binary JSON and "QTMETADATA !" for CBOR
+  data = data[index:]
+  if len(data) < 12 { return nil, errors.New("Qt metadata is truncated") }
+
+  if data[11] == '!' {
+    return parseQtCborMetadata(data[12:])
+  }
+
+  return parseQtBinaryJsonMetadata(data[12:])
+}
+
+func findElfNote(data []byte, name string, order binary.ByteOrder) ([]byte, error) {
+  for len(data) >= 12 {
+    namesz := int(order.Uint32(data[0:4]))
+    descsz := int(order.Uint32(data[4:8]))
+    nameEnd := 12 + align4(namesz)
+    descEnd := nameEnd + align4(descsz)
+    if descEnd > len(data) || nameEnd + descsz > len(data) { break }
+
+    noteName := strings.TrimRight(string(data[12:12 + namesz]), "\x00")
+    if noteName == name {
+      return data[nameEnd:nameEnd + descsz], nil
+    }
+
+    data = data[descEnd:]
+  }
+
+  return nil, fmt.Errorf("Note %v is not found", name)
+}
+
+func align4(size int) int {
+  return (size + 3) &^ 3
+}
+
+// data starts with the header: metadata version, Qt major and minor, requirements
+func parseQtCborMetadata(data []byte) (*QtPluginMetadata, error) {
+  if len(data) < 4 { return nil, errors.New("Qt metadata header is truncated") }
+
+  decoder := &cborDecoder{ data: data[4:] }
+  value, err := decoder.decode()
+  if err != nil { return nil, err }
+
+  root, ok := value.(map[interface{}]interface{})
+  if !ok { return nil, errors.New("Qt metadata is not a map") }
+
+  metadata := &QtPluginMetadata{
+    QtVersion: fmt.Sprintf("%d.%d", data[1], data[2]),
+  }
+
+  metadata.IID, _ = root[uint64(QT_METADATA_IID)].(string)
+  metadata.ClassName, _ = root[uint64(QT_METADATA_CLASSNAME)].(string)
+
+  if pluginData, ok := root[uint64(QT_METADATA_METADATA)].(map[interface{}]interface{}); ok {
+    metadata.Keys = stringList(pluginData["Keys"])
+  }
+
+  return metadata, nil
+}
+
+func parseQtBinaryJsonMetadata(data []byte) (*QtPluginMetadata, error) {
+  // header is "qbjs" tag and version
+  if len(data) < 8 || string(data[0:4]) != "qbjs" {
+    return nil, errors.New("Qt binary JSON header is not found")
+  }
+
+  value, err := parseBinaryJsonBase(data[8:], true)
+  if err != nil { return nil, err }
+
+  root, ok := value.(map[interface{}]interface{})
+  if !ok { return nil, errors.New("Qt metadata is not an object") }
+
+  metadata := &QtPluginMetadata{}
+  metadata.IID, _ = root["IID"].(string)
+  metadata.ClassName, _ = root["className"].(string)
+
+  // QT_VERSION encoded as 0xMMNNPP
+  if version, ok := root["version"].(float64); ok {
+    v := int(version)
+    metadata.QtVersion = fmt.Sprintf("%d.%d", (v >> 16) & 0xff, (v >> 8) & 0xff)
+  }
+
+  if pluginData, ok := root["MetaData"].(map[interface{}]interface{}); ok {
+    metadata.Keys = stringList(pluginData["Keys"])
+  }
+
+  return metadata, nil
+}
+
+func stringList(value interface{}) []string {
+  items, ok := value.([]interface{})
+  if !ok { return nil }
+
+  result := make([]string, 0, len(items))
+  for _, item := range items {
+    if s, ok := item.(string); ok {
+      result = append(result, s)
+    }
+  }
+
+  return result
+}
+
+// binary JSON from Qt 5: base is size, is_object bit with length, offset of the table
+func parseBinaryJsonBase(base []byte, isObject bool) (interface{}, error) {
+  if len(base) < 12 { return nil, errors.New("Binary JSON value is truncated") }
+
+  size := int(binary.LittleEndian.Uint32(base[0:4]))
+  if size > len(base) || size < 12 { return nil, errors.New("Wrong size of binary JSON value") }
+  base = base[:size]
+
+  length := int(binary.LittleEndian.Uint32(base[4:8]) >> 1)
+  tableOffset := int(binary.LittleEndian.Uint32(base[8:12]))
+  if tableOffset + length * 4 > size { return nil, errors.New("Wrong binary JSON table") }
+
+  if isObject {
+    object := make(map[interface{}]interface{})
+
+    for i := 0; i < length; i++ {
+      entryOffset := int(binary.LittleEndian.Uint32(base[tableOffset + i * 4:]))
+      if entryOffset + 4 > size { return nil, errors.New("Wrong binary JSON entry") }
+
+      valueBits := binary.LittleEndian.Uint32(base[entryOffset:])
+      key, err := parseBinaryJsonString(base[entryOffset + 4:], valueBits & (1 << 4) != 0)
+      if err != nil { return nil, err }
+
+      value, err := parseBinaryJsonValue(base, valueBits)
+      if err != nil { return nil, err }
+
+      object[key] = value
+    }
+
+    return object, nil
+  }
+
+  array := make([]interface{}, 0, length)
+  for i := 0; i < length; i++ {
+    value, err := parseBinaryJsonValue(base, binary.LittleEndian.Uint32(base[tableOffset + i * 4:]))
+    if err != nil { return nil, err }
+    array = append(array, value)
+  }
+
+  return array, nil
+}
+
+func parseBinaryJsonValue(base []byte, bits uint32) (interface{}, error) {
+  valueType := bits & 0x7
+  latinOrInt := bits & (1 << 3) != 0
+  offset := int(bits >> 5)
+
+  if valueType == 0 { return nil, nil }
+  if valueType == 1 { return offset != 0, nil }
+
+  if valueType == 2 && latinOrInt {
+    // signed 27-bit integer
+    return float64(int32(bits) >> 5), nil
+  }
+
+  if offset >= len(base) { return nil, errors.New("Wrong binary JSON value offset") }
+
+  switch valueType {
+  case 2:
+    if offset + 8 > len(base) { return nil, errors.New("Binary JSON double is truncated") }
+    return math.Float64frombits(binary.LittleEndian.Uint64(base[offset:])), nil
+  case 3: return parseBinaryJsonString(base[offset:], latinOrInt)
+  case 4: return parseBinaryJsonBase(base[offset:], false)
+  case 5: return parseBinaryJsonBase(base[offset:], true)
+  }
+
+  return nil, fmt.Errorf("Unknown binary JSON type %v", valueType)
+}
+
+// latin1 strings have 16-bit length, other strings are UTF-16 with 32-bit length
+func parseBinaryJsonString(data []byte, latin1 bool) (string, error) {
+  if latin1 {
+    if len(data) < 2 { return "", errors.New("Binary JSON string is truncated") }
+    length := int(binary.LittleEndian.Uint16(data))
+    if 2 + length > len(data) { return "", errors.New("Binary JSON string is truncated") }
+    return string(data[2:2 + length]), nil
+  }
+
+  if len(data) < 4 { return "", errors.New("Binary JSON string is truncated") }
+  length := int(binary.LittleEndian.Uint32(data))
+  if 4 + length * 2 > len(data) { return "", errors.New("Binary JSON string is truncated") }
+
+  units := make([]uint16, length)
+  for i := range units {
+    units[i] = binary.LittleEndian.Uint16(data[4 + i * 2:])
+  }
+
+  return string(utf16.Decode(units)), nil
+}
+
+// minimal CBOR decoder sufficient for Qt plugin metadata
+type cborDecoder struct {
+  data []byte
+  pos int
+}
+
+var errCborBreak = errors.New("CBOR break")
+
+func (cd *cborDecoder) readByte() (byte, error) {
+  if cd.pos >= len(cd.data) { return 0, errors.New("CBOR data is truncated") }
+  b := cd.data[cd.pos]
+  cd.pos++
+  return b, nil
+}
+
+func (cd *cborDecoder) readBytes(n uint64) ([]byte, error) {
+  if n > uint64(len(cd.data) - cd.pos) { return nil, errors.New("CBOR data is truncated") }
+  result := cd.data[cd.pos:cd.pos + int(n)]
+  cd.pos += int(n)
+  return result, nil
+}
+
+// reads argument of the item, indefinite is true for additional info 31
+func (cd *cborDecoder) readArgument(info byte) (value uint64, indefinite bool, err error) {
+  switch {
+  case info < 24: return uint64(info), false, nil
+  case info == 31: return 0, true, nil
+  case info > 27: return 0, false, fmt.Errorf("Unsupported CBOR additional info %v", info)
+  }
+
+  raw, err := cd.readBytes(1 << (info - 24))
+  if err != nil { return 0, false, err }
+
+  for _, b := range raw {
+    value = value << 8 | uint64(b)
+  }
+
+  return value, false, nil
+}
+
+func (cd *cborDecoder) decode() (interface{}, error) {
+  initial, err := cd.readByte()
+  if err != nil { return nil, err }
+
+  if initial == 0xff { return nil, errCborBreak }
+
+  major := initial >> 5
+  info := initial & 0x1f
+
+  if major == 7 {
+    switch info {
+    case 20: return false, nil
+    case 21: return true, nil
+    case 22, 23: return nil, nil
+    case 25:
+      raw, err := cd.readBytes(2)
+      if err != nil { return nil, err }
+      return float64(halfToFloat(binary.BigEndian.Uint16(raw))), nil
+    case 26:
+      raw, err := cd.readBytes(4)
+      if err != nil { return nil, err }
+      return float64(math.Float32frombits(binary.BigEndian.Uint32(raw))), nil
+    case 27:
+      raw, err := cd.readBytes(8)
+      if err != nil { return nil, err }
+      return math.Float64frombits(binary.BigEndian.Uint64(raw)), nil
+    }
+
+    if info < 24 { return nil, nil }
+    _, err := cd.readBytes(1)
+    return nil, err
+  }
+
+  argument, indefinite, err := cd.readArgument(info)
+  if err != nil { return nil, err }
+
+  switch major {
+  case 0: return argument, nil
+  case 1: return -1 - int64(argument), nil
+  case 2, 3:
+    var buffer []byte
+    if indefinite {
+      for {
+        chunk, err := cd.decode()
+        if err == errCborBreak { break }
+        if err != nil { return nil, err }
+        switch c := chunk.(type) {
+        case string: buffer = append(buffer, c...)
+        case []byte: buffer = append(buffer, c...)
+        }
+      }
+    } else if buffer, err = cd.readBytes(argument); err != nil {
+      return nil, err
+    }
+
+    if major == 3 { return string(buffer), nil }
+    return buffer, nil
+  case 4:
+    array := make([]interface{}, 0)
+    for i := uint64(0); indefinite || i < argument; i++ {
+      item, err := cd.decode()
+      if indefinite && err == errCborBreak { break }
+      if err != nil { return nil, err }
+      array = append(array, item)
+    }
+    return array, nil
+  case 5:
+    object := make(map[interface{}]interface{})
+    for i := uint64(0); indefinite || i < argument; i++ {
+      key, err := cd.decode()
+      if indefinite && err == errCborBreak { break }
+      if err != nil { return nil, err }
+
+      value, err := cd.decode()
+      if err != nil { return nil, err }
+
+      switch key.(type) {
+      case string, uint64, int64, bool:
+        object[key] = value
+      default:
+        object[fmt.Sprintf("%v", key)] = value
+      }
+    }
+    return object, nil
+  case 6:
+    // tags are not interesting, only tagged value
+    return cd.decode()
+  }
+
+  return nil, fmt.Errorf("Unsupported CBOR major type %v", major)
+}
+
+func halfToFloat(half uint16) float32 {
+  sign := uint32(half >> 15) << 31
+  exponent := uint32(half >> 10) & 0x1f
+  mantissa := uint32(half) & 0x3ff
+
+  switch exponent {
+  case 0:
+    // subnormal number
+    value := float32(mantissa) / (1 << 24)
+    if sign != 0 { value = -value }
+    return value
+  case 0x1f:
+    return math.Float32frombits(sign | 0x7f800000 | mantissa << 13)
+  }
+
+  return math.Float32frombits(sign | (exponent + 112) << 23 | mantissa << 13)
+}
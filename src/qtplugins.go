@@ -0,0 +1,235 @@
+/*
+ * This file is a part of linuxdeploy - tool for
+ * creating standalone applications for Linux
+ *
+ * Copyright (C) 2017 Taras Kushnir <kushnirTV@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the MIT License.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ */
+
+package main
+
+import (
+  "log"
+  "strings"
+  "path/filepath"
+)
+
+// describes what has to be deployed together with Qt module
+type QtPluginRule struct {
+  Module string `json:"module"` // name without lib prefix and Qt version, e.g. "gui"
+  Qt int `json:"qt,omitempty"` // only for this Qt major version if not 0
+  PluginDirs []string `json:"plugin_dirs,omitempty"` // relative to Qt plugins dir
+  Plugins []string `json:"plugins,omitempty"` // relative to Qt plugins dir
+  LibExecs []string `json:"libexecs,omitempty"` // relative to Qt libexecs dir
+  DataDirs []string `json:"data_dirs,omitempty"` // relative to Qt data dir
+  TranslationDirs []string `json:"translation_dirs,omitempty"` // relative to Qt translations dir
+  Extend bool `json:"extend,omitempty"` // in project config: add to built-in rule instead of replacing it
+}
+
+// modules are from qttranslations.go, the ones without plugins are listed too
+var builtinQtPluginRules = []QtPluginRule {
+  { Module: "bluetooth" },
+  { Module: "clucene" },
+  { Module: "concurrent" },
+  { Module: "core" },
+  { Module: "declarative", PluginDirs: []string{ "qml1tooling" } },
+  { Module: "designercomponents" },
+  { Module: "designer", PluginDirs: []string{ "designer" } },
+  { Module: "gui",
+    Plugins: []string{ "platforms/libqxcb.so" },
+    PluginDirs: []string{ "imageformats", "platforminputcontexts", "platformthemes" } },
+  { Module: "help" },
+  { Module: "multimedia", Qt: 5, PluginDirs: []string{ "mediaservice", "audio", "playlistformats" } },
+  { Module: "multimedia", Qt: 6, PluginDirs: []string{ "multimedia" } },
+  { Module: "multimediawidgets" },
+  { Module: "multimediaquick_p" },
+  { Module: "network", Qt: 5, PluginDirs: []string{ "bearer" } },
+  // bearer plugins were replaced with tls backends and network information in Qt 6
+  { Module: "network", Qt: 6, PluginDirs: []string{ "tls", "networkinformation" } },
+  { Module: "nfc" },
+  { Module: "opengl", PluginDirs: []string{ "xcbglintegrations" } },
+  { Module: "positioning", PluginDirs: []string{ "position" } },
+  { Module: "printsupport", Plugins: []string{ "printsupport/libcupsprintersupport.so" } },
+  { Module: "qml" },
+  { Module: "quick" },
+  { Module: "quickparticles" },
+  { Module: "script" },
+  { Module: "scripttools" },
+  { Module: "sensors", PluginDirs: []string{ "sensors", "sensorgestures" } },
+  { Module: "serialport" },
+  { Module: "sql", PluginDirs: []string{ "sqldrivers" } },
+  { Module: "svg", Plugins: []string{ "iconengines/libqsvgicon.so" } },
+  { Module: "test" },
+  { Module: "widgets", PluginDirs: []string{ "styles" } },
+  { Module: "xml" },
+  { Module: "xmlpatterns" },
+  { Module: "webkit" },
+  { Module: "webkitwidgets" },
+  { Module: "quickwidgets" },
+  { Module: "websockets" },
+  { Module: "enginio" },
+  { Module: "webenginecore",
+    LibExecs: []string{ "QtWebEngineProcess" },
+    DataDirs: []string{ "resources" },
+    TranslationDirs: []string{ "qtwebengine_locales" } },
+  { Module: "webengine" },
+  { Module: "webenginewidgets" },
+  { Module: "qmltooling" },
+  { Module: "3dcore" },
+  { Module: "3drender", PluginDirs: []string{ "sceneparsers", "renderplugins", "geometryloaders" } },
+  { Module: "3drenderer", PluginDirs: []string{ "sceneparsers", "renderplugins", "geometryloaders" } },
+  { Module: "3dquick" },
+  { Module: "3dquickrender" },
+  { Module: "3dquickrenderer" },
+  { Module: "3dinput" },
+  { Module: "location", PluginDirs: []string{ "geoservices" } },
+  { Module: "webchannel" },
+  { Module: "texttospeech", PluginDirs: []string{ "texttospeech" } },
+  { Module: "serialbus", PluginDirs: []string{ "canbus" } },
+
+  // modules which are not in the windeployqt list
+  { Module: "xcbqpa", PluginDirs: []string{ "xcbglintegrations" } },
+  { Module: "eglfsdeviceintegration", PluginDirs: []string{ "egldeviceintegrations" } },
+  { Module: "gamepad", PluginDirs: []string{ "gamepads" } },
+  { Module: "virtualkeyboard", PluginDirs: []string{ "virtualkeyboard" } },
+  { Module: "openglwidgets" },
+  { Module: "waylandclient",
+    PluginDirs: []string{ "wayland-shell-integration", "wayland-decoration-client", "wayland-graphics-integration-client" } },
+  { Module: "dbus" },
+  { Module: "qmlmodels" },
+  { Module: "qmlworkerscript" },
+  { Module: "quicktemplates2" },
+  { Module: "quickcontrols2" },
+
+  // modules which appeared or were split out in Qt 6
+  { Module: "core5compat", Qt: 6 },
+  { Module: "multimediaquick", Qt: 6 },
+  { Module: "quickdialogs2", Qt: 6 },
+  { Module: "shadertools", Qt: 6 },
+  { Module: "statemachine", Qt: 6 },
+  { Module: "svgwidgets", Qt: 6 },
+  { Module: "webenginequick", Qt: 6 },
+}
+
+// platform plugins bring libQt5WaylandClient which is processed as usual Qt lib
+var waylandQtPluginRules = []QtPluginRule {
+  { Module: "gui", Plugins: []string{ "platforms/libqwayland-*.so" }, Extend: true },
+}
+
+func (rule *QtPluginRule) matches(module string, qtVersion int) bool {
+  return rule.Module == module && (rule.Qt == 0 || rule.Qt == qtVersion)
+}
+
+// rules from the project config replace built-in rules for the same module and Qt version
+// (all versions if Qt is not set) or are added to them if extend is set
+func mergeQtPluginRules(builtin, custom []QtPluginRule) []QtPluginRule {
+  rules := make([]QtPluginRule, len(builtin))
+  copy(rules, builtin)
+
+  for _, customRule := range custom {
+    found := false
+    merged := make([]QtPluginRule, 0, len(rules) + 1)
+
+    for _, rule := range rules {
+      if rule.Module != customRule.Module || (customRule.Qt != 0 && rule.Qt != customRule.Qt) {
+        merged = append(merged, rule)
+        continue
+      }
+
+      if customRule.Extend {
+        rule.PluginDirs = append(append([]string{}, rule.PluginDirs...), customRule.PluginDirs...)
+        rule.Plugins = append(append([]string{}, rule.Plugins...), customRule.Plugins...)
+        rule.LibExecs = append(append([]string{}, rule.LibExecs...), customRule.LibExecs...)
+        rule.DataDirs = append(append([]string{}, rule.DataDirs...), customRule.DataDirs...)
+        rule.TranslationDirs = append(append([]string{}, rule.TranslationDirs...), customRule.TranslationDirs...)
+        merged = append(merged, rule)
+      } else if !found {
+        // rule for all versions is kept only once
+        merged = append(merged, customRule)
+      }
+
+      found = true
+    }
+
+    if !found {
+      merged = append(merged, customRule)
+    }
+
+    rules = merged
+  }
+
+  return rules
+}
+
+func (ad *AppDeployer) deployQtPluginRules(module string, qtVersion int) {
+  deployFlags := LDD_DEPENDENCY_FLAG | DEPLOY_ONLY_LIBRARIES_FLAG | FIX_RPATH_FLAG
+  qd := ad.qtDeployer
+  matched := false
+
+  for _, rule := range qd.pluginRules {
+    if !rule.matches(module, qtVersion) { continue }
+    matched = true
+
+    for _, pattern := range rule.Plugins {
+      for _, plugin := range qd.expandPluginPattern(pattern) {
+        ad.addQtPluginTask(plugin)
+      }
+    }
+
+    for _, pluginDir := range rule.PluginDirs {
+      ad.deployRecursively(qd.PluginsPath(), pluginDir, "plugins", deployFlags)
+    }
+
+    for _, libexec := range rule.LibExecs {
+      ad.addCopyQtDepTask(qd.LibExecsPath(), libexec, "libexecs")
+    }
+
+    for _, dataDir := range rule.DataDirs {
+      ad.copyRecursively(qd.DataPath(), dataDir, ".")
+    }
+
+    for _, translationDir := range rule.TranslationDirs {
+      ad.copyRecursively(qd.TranslationsPath(), translationDir, "translations")
+    }
+  }
+
+  if !matched {
+    log.Printf("No plugin rules for Qt module %v", module)
+  }
+}
+
+// returns plugins matching the pattern relative to Qt plugins dir
+func (qd *QtDeployer) expandPluginPattern(pattern string) []string {
+  if !strings.ContainsAny(pattern, "*?[") { return []string{ pattern } }
+
+  matches, err := filepath.Glob(filepath.Join(qd.PluginsPath(), pattern))
+  if err != nil || len(matches) == 0 {
+    log.Printf("No plugins found for %v", pattern)
+    return nil
+  }
+
+  plugins := make([]string, 0, len(matches))
+  for _, match := range matches {
+    if relativePath, err := filepath.Rel(qd.PluginsPath(), match); err == nil {
+      plugins = append(plugins, relativePath)
+    }
+  }
+
+  return plugins
+}
+
+// built-in rules with optional ones enabled by cmdline flags
+func defaultQtPluginRules() []QtPluginRule {
+  rules := builtinQtPluginRules
+  if *waylandFlag {
+    rules = mergeQtPluginRules(rules, waylandQtPluginRules)
+  }
+
+  return rules
+}
@@ -22,6 +22,7 @@ import (
   "os"
   "os/exec"
   "sync"
+  "time"
 )
 
 // list of modules from windeployqt
@@ -130,12 +131,71 @@ var moduleToTranslationMap = map[string]string {
   /*QtSerialBusModule:*/ "qt5serialbus": "",
 }
 
+// Qt 6 dropped some modules and moved others to new catalogs
+var qt6ModuleToTranslationMap = map[string]string {
+  "qt6bluetooth": "qtconnectivity",
+  "qt6concurrent": "qtbase",
+  "qt6core": "qtbase",
+  "qt6core5compat": "qtbase",
+  "qt6dbus": "qtbase",
+  "qt6designer": "",
+  "qt6designercomponents": "",
+  "qt6gui": "qtbase",
+  "qt6help": "qt_help",
+  "qt6multimedia": "qtmultimedia",
+  "qt6multimediawidgets": "qtmultimedia",
+  "qt6multimediaquick": "qtmultimedia",
+  "qt6network": "qtbase",
+  "qt6nfc": "qtconnectivity",
+  "qt6opengl": "qtbase",
+  "qt6openglwidgets": "qtbase",
+  "qt6positioning": "qtlocation",
+  "qt6printsupport": "qtbase",
+  "qt6qml": "qtdeclarative",
+  "qt6qmlmodels": "qtdeclarative",
+  "qt6qmlworkerscript": "qtdeclarative",
+  "qt6quick": "qtdeclarative",
+  "qt6quickcontrols2": "qtdeclarative",
+  "qt6quickdialogs2": "qtdeclarative",
+  "qt6quicktemplates2": "qtdeclarative",
+  "qt6quickwidgets": "qtdeclarative",
+  "qt6quickparticles": "",
+  "qt6sensors": "",
+  "qt6serialport": "qtserialport",
+  "qt6serialbus": "qtserialbus",
+  "qt6sql": "qtbase",
+  "qt6statemachine": "",
+  "qt6svg": "",
+  "qt6svgwidgets": "",
+  "qt6test": "qtbase",
+  "qt6widgets": "qtbase",
+  "qt6xml": "qtbase",
+  "qt6websockets": "qtwebsockets",
+  "qt6webenginecore": "qtwebengine",
+  "qt6webengine": "qtwebengine",
+  "qt6webenginequick": "qtwebengine",
+  "qt6webenginewidgets": "qtwebengine",
+  "qt6webchannel": "",
+  "qt6location": "qtlocation",
+  "qt6texttospeech": "",
+  "qt63dcore": "",
+  "qt63drender": "",
+  "qt63dquick": "",
+  "qt63dinput": "",
+  "qt6shadertools": "",
+  "qt6xcbqpa": "",
+  "qt6waylandclient": "",
+}
+
 func (qd *QtDeployer) accountQtLibrary(libname string) {
   extensionIndex := strings.LastIndex(libname, ".so")
   if extensionIndex == -1 { return }
 
   libprefix := libname[3:extensionIndex]
-  if translation, ok := moduleToTranslationMap[libprefix]; ok {
+  translation, ok := moduleToTranslationMap[libprefix]
+  if !ok { translation, ok = qt6ModuleToTranslationMap[libprefix] }
+
+  if ok {
     if len(translation) > 0 {
       qd.translationsRequired[translation] = true
       log.Printf("Accounted translation %v for lib %v", translation, libname)
@@ -149,6 +209,9 @@ func (ad *AppDeployer) deployQtTranslations(translationsRoot string, mainWaitGro
   defer mainWaitGroup.Done()
   if !ad.qtDeployer.qtEnvironmentSet { return }
 
+  start := time.Now()
+  defer ad.report.addStageTime(STAGE_TRANSLATIONS, start)
+
   qtTranslationsPath := ad.qtDeployer.TranslationsPath()
 
   languages := retrieveAvailableLanguages(qtTranslationsPath)
@@ -200,8 +263,10 @@ func (ad *AppDeployer) deployLanguage(lang, lconvertPath, translationsRoot strin
   err := exec.Command(lconvertPath, arguments...).Run()
   if err != nil {
     log.Printf("lconvert failed with %v", err)
+    ad.report.accountFailed()
   } else {
     log.Printf("Generated translations file %v", outputFile)
+    ad.report.accountDeployed(CATEGORY_TRANSLATION)
   }
 }
 
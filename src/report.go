@@ -0,0 +1,300 @@
+/*
+ * This file is a part of linuxdeploy - tool for
+ * creating standalone applications for Linux
+ *
+ * Copyright (C) 2017 Taras Kushnir <kushnirTV@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the MIT License.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ */
+
+package main
+
+import (
+  "log"
+  "io"
+  "fmt"
+  "sync"
+  "time"
+  "strings"
+  "path/filepath"
+  "encoding/json"
+  "io/ioutil"
+)
+
+type PipelineStage int
+
+const (
+  STAGE_LDD PipelineStage = iota
+  STAGE_COPY
+  STAGE_RPATH
+  STAGE_STRIP
+  STAGE_QT
+  STAGE_TRANSLATIONS
+  STAGES_COUNT
+)
+
+var stageNames = [STAGES_COUNT]string {
+  "ldd",
+  "copy",
+  "rpath",
+  "strip",
+  "qt",
+  "translations",
+}
+
+type FileCategory int
+
+const (
+  CATEGORY_LIBRARY FileCategory = iota
+  CATEGORY_PLUGIN
+  CATEGORY_QML
+  CATEGORY_TRANSLATION
+  CATEGORY_DATA
+)
+
+type DeployReport struct {
+  mutex sync.Mutex
+  startTime time.Time
+
+  Libraries int `json:"libraries"`
+  Plugins int `json:"plugins"`
+  QmlModules int `json:"qml_modules"`
+  QmlFiles int `json:"qml_files"`
+  Translations int `json:"translations"`
+  DataFiles int `json:"data_files"`
+
+  Blacklisted int `json:"blacklisted"`
+  Skipped int `json:"skipped"`
+  Failed int `json:"failed"`
+
+  AppDirSize int64 `json:"appdir_size"`
+  TotalTime float64 `json:"total_seconds"`
+  StageTimes map[string]float64 `json:"stage_seconds"`
+  Sizes *SizeBreakdown `json:"sizes,omitempty"`
+  QtCorePatches []QtCorePatch `json:"qtcore_patches,omitempty"`
+  PluginMetadata []QtPluginMetadata `json:"plugin_metadata,omitempty"`
+  ExtraLibraries []ExtraLibrary `json:"extra_libraries,omitempty"`
+  HostLibraries []HostLibrary `json:"host_libraries,omitempty"`
+
+  stageDurations [STAGES_COUNT]time.Duration
+}
+
+// library which is not a dependency reported by ldd
+type ExtraLibrary struct {
+  Path string `json:"path"`
+  Origin string `json:"origin"`
+}
+
+// library deliberately not deployed due to runtime dependency rules
+type HostLibrary struct {
+  Path string `json:"path"`
+  Reason string `json:"reason"`
+}
+
+func NewDeployReport() *DeployReport {
+  return &DeployReport{
+    startTime: time.Now(),
+    StageTimes: make(map[string]float64),
+  }
+}
+
+func categorizeTarget(targetPath string) FileCategory {
+  topDir := strings.SplitN(filepath.ToSlash(filepath.Clean(targetPath)), "/", 2)[0]
+
+  switch topDir {
+  case "lib": return CATEGORY_LIBRARY
+  case "plugins": return CATEGORY_PLUGIN
+  case "qml": return CATEGORY_QML
+  case "translations": return CATEGORY_TRANSLATION
+  }
+
+  return CATEGORY_DATA
+}
+
+func (dr *DeployReport) accountDeployed(category FileCategory) {
+  dr.mutex.Lock()
+  defer dr.mutex.Unlock()
+
+  switch category {
+  case CATEGORY_LIBRARY: dr.Libraries++
+  case CATEGORY_PLUGIN: dr.Plugins++
+  case CATEGORY_QML: dr.QmlFiles++
+  case CATEGORY_TRANSLATION: dr.Translations++
+  default: dr.DataFiles++
+  }
+}
+
+func (dr *DeployReport) accountQmlModule() {
+  dr.mutex.Lock()
+  dr.QmlModules++
+  dr.mutex.Unlock()
+}
+
+func (dr *DeployReport) accountSkipped() {
+  dr.mutex.Lock()
+  dr.Skipped++
+  dr.mutex.Unlock()
+}
+
+func (dr *DeployReport) accountFailed() {
+  dr.mutex.Lock()
+  dr.Failed++
+  dr.mutex.Unlock()
+}
+
+func (dr *DeployReport) accountBlacklisted(count int) {
+  dr.mutex.Lock()
+  dr.Blacklisted += count
+  dr.mutex.Unlock()
+}
+
+func (dr *DeployReport) setQtCorePatches(patches []QtCorePatch) {
+  dr.mutex.Lock()
+  dr.QtCorePatches = patches
+  dr.mutex.Unlock()
+}
+
+func (dr *DeployReport) accountExtraLibrary(path, origin string) {
+  dr.mutex.Lock()
+  dr.ExtraLibraries = append(dr.ExtraLibraries, ExtraLibrary{ Path: path, Origin: origin })
+  dr.mutex.Unlock()
+}
+
+func (dr *DeployReport) accountHostLibrary(path, reason string) {
+  dr.mutex.Lock()
+  dr.HostLibraries = append(dr.HostLibraries, HostLibrary{ Path: path, Reason: reason })
+  dr.mutex.Unlock()
+}
+
+func (dr *DeployReport) setPluginMetadata(plugins []QtPluginMetadata) {
+  dr.mutex.Lock()
+  dr.PluginMetadata = plugins
+  dr.mutex.Unlock()
+}
+
+// measures time spent in the stage since start
+func (dr *DeployReport) addStageTime(stage PipelineStage, start time.Time) {
+  elapsed := time.Since(start)
+
+  dr.mutex.Lock()
+  dr.stageDurations[stage] += elapsed
+  dr.mutex.Unlock()
+}
+
+func (dr *DeployReport) finish(appDirPath string) {
+  sizes, err := analyzeSizes(appDirPath)
+  if err != nil { log.Printf("Error while calculating size of %v: %v", appDirPath, err) }
+
+  dr.mutex.Lock()
+  defer dr.mutex.Unlock()
+
+  dr.Sizes = sizes
+  dr.AppDirSize = sizes.Total
+  dr.TotalTime = time.Since(dr.startTime).Seconds()
+
+  for i, duration := range dr.stageDurations {
+    dr.StageTimes[stageNames[i]] = duration.Seconds()
+  }
+}
+
+func (dr *DeployReport) printSummary(w io.Writer) {
+  dr.mutex.Lock()
+  defer dr.mutex.Unlock()
+
+  fmt.Fprintln(w, "Deployment summary:")
+  fmt.Fprintf(w, "  libraries:    %v\n", dr.Libraries)
+  fmt.Fprintf(w, "  plugins:      %v\n", dr.Plugins)
+  fmt.Fprintf(w, "  qml modules:  %v (%v files)\n", dr.QmlModules, dr.QmlFiles)
+  fmt.Fprintf(w, "  translations: %v\n", dr.Translations)
+  fmt.Fprintf(w, "  data files:   %v\n", dr.DataFiles)
+  fmt.Fprintf(w, "  blacklisted:  %v\n", dr.Blacklisted)
+  fmt.Fprintf(w, "  skipped:      %v\n", dr.Skipped)
+  fmt.Fprintf(w, "  failed:       %v\n", dr.Failed)
+  fmt.Fprintf(w, "  AppDir size:  %v\n", formatSize(dr.AppDirSize))
+
+  for i, duration := range dr.stageDurations {
+    fmt.Fprintf(w, "  %-13s %.2fs\n", stageNames[i] + ":", duration.Seconds())
+  }
+
+  fmt.Fprintf(w, "  total:        %.2fs\n", dr.TotalTime)
+
+  if len(dr.QtCorePatches) > 0 {
+    fmt.Fprintln(w, "QtCore patching:")
+    for _, patch := range dr.QtCorePatches {
+      status := "verified"
+      if !patch.Found {
+        status = "not found"
+      } else if len(patch.Error) > 0 {
+        status = patch.Error
+      } else if !patch.Verified {
+        status = "not verified"
+      }
+      fmt.Fprintf(w, "  %-22s %v -> %v (%v)\n", patch.Key, patch.OldValue, patch.NewValue, status)
+    }
+  }
+
+  if len(dr.ExtraLibraries) > 0 {
+    fmt.Fprintln(w, "Extra libraries:")
+    for _, library := range dr.ExtraLibraries {
+      fmt.Fprintf(w, "  %-50s %v\n", library.Path, library.Origin)
+    }
+  }
+
+  if len(dr.HostLibraries) > 0 {
+    fmt.Fprintln(w, "Left to the host:")
+    for _, library := range dr.HostLibraries {
+      fmt.Fprintf(w, "  %-50s %v\n", library.Path, library.Reason)
+    }
+  }
+
+  if len(dr.PluginMetadata) > 0 {
+    fmt.Fprintln(w, "Qt plugins:")
+    for _, plugin := range dr.PluginMetadata {
+      fmt.Fprintf(w, "  %-50s %v %v", plugin.Path, plugin.IID, strings.Join(plugin.Keys, ","))
+      if plugin.VersionMismatch {
+        fmt.Fprintf(w, " (built with Qt %v)", plugin.QtVersion)
+      }
+      fmt.Fprintln(w)
+    }
+  }
+
+  if dr.Sizes == nil { return }
+
+  fmt.Fprintln(w, "Size by category:")
+  for _, entry := range dr.Sizes.sortedCategories() {
+    fmt.Fprintf(w, "  %-30s %v\n", entry.Path, formatSize(entry.Size))
+  }
+
+  fmt.Fprintln(w, "Largest files:")
+  for _, entry := range dr.Sizes.Largest {
+    fmt.Fprintf(w, "  %-50s %v\n", entry.Path, formatSize(entry.Size))
+  }
+}
+
+func (dr *DeployReport) writeJson(path string) error {
+  dr.mutex.Lock()
+  defer dr.mutex.Unlock()
+
+  data, err := json.MarshalIndent(dr, "", "  ")
+  if err != nil { return err }
+
+  return ioutil.WriteFile(path, data, 0644)
+}
+
+func formatSize(size int64) string {
+  const unit = 1024
+  if size < unit { return fmt.Sprintf("%d B", size) }
+
+  div, exp := int64(unit), 0
+  for n := size / unit; n >= unit; n /= unit {
+    div *= unit
+    exp++
+  }
+
+  return fmt.Sprintf("%.1f %ciB", float64(size) / float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,643 @@
+/*
+ * This file is a part of linuxdeploy - tool for
+ * creating standalone applications for Linux
+ *
+ * Copyright (C) 2017 Taras Kushnir <kushnirTV@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the MIT License.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ */
+
+package main
+
+import (
+  "log"
+  "os"
+  "io"
+  "fmt"
+  "sort"
+  "bytes"
+  "time"
+  "strings"
+  "crypto/md5"
+  "crypto/sha1"
+  "crypto/sha256"
+  "encoding/binary"
+  "encoding/hex"
+  "compress/gzip"
+  "debug/elf"
+  "io/ioutil"
+  "path"
+  "path/filepath"
+)
+
+type RpmTagType uint32
+
+const (
+  RPM_NULL_TYPE RpmTagType = iota
+  RPM_CHAR_TYPE
+  RPM_INT8_TYPE
+  RPM_INT16_TYPE
+  RPM_INT32_TYPE
+  RPM_INT64_TYPE
+  RPM_STRING_TYPE
+  RPM_BIN_TYPE
+  RPM_STRING_ARRAY_TYPE
+  RPM_I18NSTRING_TYPE
+)
+
+const (
+  RPMTAG_HEADERSIGNATURES = 62
+  RPMTAG_HEADERIMMUTABLE = 63
+  RPMTAG_HEADERI18NTABLE = 100
+
+  RPMSIGTAG_SHA1 = 269
+  RPMSIGTAG_LONGSIZE = 270
+  RPMSIGTAG_LONGARCHIVESIZE = 271
+  RPMSIGTAG_SHA256 = 273
+  RPMSIGTAG_SIZE = 1000
+  RPMSIGTAG_MD5 = 1004
+  RPMSIGTAG_PAYLOADSIZE = 1007
+
+  RPMTAG_NAME = 1000
+  RPMTAG_VERSION = 1001
+  RPMTAG_RELEASE = 1002
+  RPMTAG_SUMMARY = 1004
+  RPMTAG_DESCRIPTION = 1005
+  RPMTAG_BUILDTIME = 1006
+  RPMTAG_BUILDHOST = 1007
+  RPMTAG_SIZE = 1009
+  RPMTAG_LICENSE = 1014
+  RPMTAG_PACKAGER = 1015
+  RPMTAG_GROUP = 1016
+  RPMTAG_URL = 1020
+  RPMTAG_OS = 1021
+  RPMTAG_ARCH = 1022
+  RPMTAG_FILESIZES = 1028
+  RPMTAG_FILEMODES = 1030
+  RPMTAG_FILERDEVS = 1033
+  RPMTAG_FILEMTIMES = 1034
+  RPMTAG_FILEDIGESTS = 1035
+  RPMTAG_FILELINKTOS = 1036
+  RPMTAG_FILEFLAGS = 1037
+  RPMTAG_FILEUSERNAME = 1039
+  RPMTAG_FILEGROUPNAME = 1040
+  RPMTAG_SOURCERPM = 1044
+  RPMTAG_FILEVERIFYFLAGS = 1045
+  RPMTAG_PROVIDENAME = 1047
+  RPMTAG_REQUIREFLAGS = 1048
+  RPMTAG_REQUIRENAME = 1049
+  RPMTAG_REQUIREVERSION = 1050
+  RPMTAG_RPMVERSION = 1064
+  RPMTAG_FILEDEVICES = 1095
+  RPMTAG_FILEINODES = 1096
+  RPMTAG_FILELANGS = 1097
+  RPMTAG_PROVIDEFLAGS = 1112
+  RPMTAG_PROVIDEVERSION = 1113
+  RPMTAG_DIRINDEXES = 1116
+  RPMTAG_BASENAMES = 1117
+  RPMTAG_DIRNAMES = 1118
+  RPMTAG_PAYLOADFORMAT = 1124
+  RPMTAG_PAYLOADCOMPRESSOR = 1125
+  RPMTAG_PAYLOADFLAGS = 1126
+  RPMTAG_LONGSIZE = 5009
+  RPMTAG_FILEDIGESTALGO = 5011
+)
+
+const (
+  RPMSENSE_LESS = 1 << 1
+  RPMSENSE_EQUAL = 1 << 3
+  RPMSENSE_RPMLIB = 1 << 24
+)
+
+const (
+  rpmLeadSize = 96
+  rpmDigestAlgoMD5 = 1
+  // cpio newc stores file sizes in 8 hex digits
+  rpmMaxFileSize = 1 << 32 - 1
+)
+
+var rpmHeaderMagic = []byte{0x8e, 0xad, 0xe8, 0x01, 0, 0, 0, 0}
+
+type rpmHeaderEntry struct {
+  tag uint32
+  tagType RpmTagType
+  count uint32
+  data []byte
+}
+
+// builds header structure: index of entries and data store
+type rpmHeader struct {
+  entries []rpmHeaderEntry
+}
+
+func (h *rpmHeader) add(tag uint32, tagType RpmTagType, count int, data []byte) {
+  h.entries = append(h.entries, rpmHeaderEntry{tag: tag, tagType: tagType, count: uint32(count), data: data})
+}
+
+func (h *rpmHeader) addString(tag uint32, value string) {
+  h.add(tag, RPM_STRING_TYPE, 1, append([]byte(value), 0))
+}
+
+func (h *rpmHeader) addI18NString(tag uint32, value string) {
+  h.add(tag, RPM_I18NSTRING_TYPE, 1, append([]byte(value), 0))
+}
+
+func (h *rpmHeader) addStringArray(tag uint32, values []string) {
+  buffer := &bytes.Buffer{}
+  for _, value := range values {
+    buffer.WriteString(value)
+    buffer.WriteByte(0)
+  }
+  h.add(tag, RPM_STRING_ARRAY_TYPE, len(values), buffer.Bytes())
+}
+
+func (h *rpmHeader) addInt32(tag uint32, values ...uint32) {
+  data := make([]byte, 4 * len(values))
+  for i, value := range values {
+    binary.BigEndian.PutUint32(data[i * 4:], value)
+  }
+  h.add(tag, RPM_INT32_TYPE, len(values), data)
+}
+
+func (h *rpmHeader) addInt64(tag uint32, values ...uint64) {
+  data := make([]byte, 8 * len(values))
+  for i, value := range values {
+    binary.BigEndian.PutUint64(data[i * 8:], value)
+  }
+  h.add(tag, RPM_INT64_TYPE, len(values), data)
+}
+
+// adds 32-bit size or 64-bit one with the long tag if it does not fit
+func (h *rpmHeader) addSize(tag, longTag uint32, size int64) {
+  if size > rpmMaxFileSize {
+    h.addInt64(longTag, uint64(size))
+  } else {
+    h.addInt32(tag, uint32(size))
+  }
+}
+
+func (h *rpmHeader) addInt16(tag uint32, values ...uint16) {
+  data := make([]byte, 2 * len(values))
+  for i, value := range values {
+    binary.BigEndian.PutUint16(data[i * 2:], value)
+  }
+  h.add(tag, RPM_INT16_TYPE, len(values), data)
+}
+
+func (h *rpmHeader) addBin(tag uint32, data []byte) {
+  h.add(tag, RPM_BIN_TYPE, len(data), data)
+}
+
+func rpmTypeAlignment(tagType RpmTagType) int {
+  switch tagType {
+  case RPM_INT16_TYPE: return 2
+  case RPM_INT32_TYPE: return 4
+  case RPM_INT64_TYPE: return 8
+  }
+  return 1
+}
+
+// serializes header with a region tag so that rpm treats it as immutable
+func (h *rpmHeader) bytes(regionTag uint32) []byte {
+  entries := make([]rpmHeaderEntry, len(h.entries))
+  copy(entries, h.entries)
+  sort.SliceStable(entries, func(i, j int) bool { return entries[i].tag < entries[j].tag })
+
+  indexCount := len(entries) + 1
+  store := &bytes.Buffer{}
+  index := &bytes.Buffer{}
+
+  writeIndexEntry := func(tag uint32, tagType RpmTagType, offset int32, count uint32) {
+    binary.Write(index, binary.BigEndian, tag)
+    binary.Write(index, binary.BigEndian, uint32(tagType))
+    binary.Write(index, binary.BigEndian, offset)
+    binary.Write(index, binary.BigEndian, count)
+  }
+
+  offsets := make([]int32, len(entries))
+  for i, entry := range entries {
+    alignment := rpmTypeAlignment(entry.tagType)
+    for store.Len() % alignment != 0 {
+      store.WriteByte(0)
+    }
+
+    offsets[i] = int32(store.Len())
+    store.Write(entry.data)
+  }
+
+  // region trailer is the last thing in the store and points back to the index start
+  trailerOffset := int32(store.Len())
+  binary.Write(store, binary.BigEndian, regionTag)
+  binary.Write(store, binary.BigEndian, uint32(RPM_BIN_TYPE))
+  binary.Write(store, binary.BigEndian, int32(-indexCount * 16))
+  binary.Write(store, binary.BigEndian, uint32(16))
+
+  writeIndexEntry(regionTag, RPM_BIN_TYPE, trailerOffset, 16)
+  for i, entry := range entries {
+    writeIndexEntry(entry.tag, entry.tagType, offsets[i], entry.count)
+  }
+
+  result := &bytes.Buffer{}
+  result.Write(rpmHeaderMagic)
+  binary.Write(result, binary.BigEndian, uint32(indexCount))
+  binary.Write(result, binary.BigEndian, uint32(store.Len()))
+  result.Write(index.Bytes())
+  result.Write(store.Bytes())
+
+  return result.Bytes()
+}
+
+type rpmFile struct {
+  archivePath string // absolute path in the target system
+  sourcePath string // empty for files from memory
+  contents []byte
+  link string
+  mode uint32 // unix mode with file type bits
+  size int64
+  mtime time.Time
+  digest string
+}
+
+func (ad *AppDeployer) createRpmPackage() error {
+  metadata := ad.packageMetadata()
+
+  launcherPath := filepath.Join("bin", ad.appName())
+  if err := ad.generateLauncherScript(launcherPath); err != nil { return err }
+
+  machine, err := elfMachine(ad.targetExePath)
+  if err != nil { return err }
+  arch := rpmArchitecture(machine)
+
+  installRoot := path.Join("/opt", metadata.Name)
+  files, err := ad.collectRpmFiles(installRoot, ad.systemIntegrationFiles(metadata, installRoot))
+  if err != nil { return err }
+
+  outputPath := ad.packageOutputPath(fmt.Sprintf("%s-%s-%s.%s.rpm", metadata.Name, metadata.Version, metadata.Release, arch))
+  log.Printf("Creating RPM package %v", outputPath)
+
+  payloadFile, err := ioutil.TempFile(filepath.Dir(outputPath), ".linuxdeploy-payload-")
+  if err != nil { return err }
+  defer os.Remove(payloadFile.Name())
+  defer payloadFile.Close()
+
+  payloadSize, err := writeRpmPayload(payloadFile, files)
+  if err != nil { return err }
+
+  header := rpmMainHeader(metadata, arch, files).bytes(RPMTAG_HEADERIMMUTABLE)
+
+  if _, err = payloadFile.Seek(0, io.SeekStart); err != nil { return err }
+  md5Hash := md5.New()
+  md5Hash.Write(header)
+  compressedSize, err := io.Copy(md5Hash, payloadFile)
+  if err != nil { return err }
+
+  signature := &rpmHeader{}
+  signature.addString(RPMSIGTAG_SHA1, fmt.Sprintf("%x", sha1.Sum(header)))
+  signature.addString(RPMSIGTAG_SHA256, fmt.Sprintf("%x", sha256.Sum256(header)))
+  signature.addSize(RPMSIGTAG_SIZE, RPMSIGTAG_LONGSIZE, int64(len(header)) + compressedSize)
+  signature.addBin(RPMSIGTAG_MD5, md5Hash.Sum(nil))
+  signature.addSize(RPMSIGTAG_PAYLOADSIZE, RPMSIGTAG_LONGARCHIVESIZE, payloadSize)
+
+  signatureBytes := signature.bytes(RPMTAG_HEADERSIGNATURES)
+  // signature is padded to 8 bytes boundary
+  for len(signatureBytes) % 8 != 0 {
+    signatureBytes = append(signatureBytes, 0)
+  }
+
+  lead := rpmLead(fmt.Sprintf("%s-%s-%s", metadata.Name, metadata.Version, metadata.Release), machine)
+  if err = writeRpmFile(outputPath, [][]byte{lead, signatureBytes, header}, payloadFile); err != nil { return err }
+
+  log.Printf("RPM package created at %v", outputPath)
+  return nil
+}
+
+func writeRpmFile(outputPath string, headers [][]byte, payloadFile *os.File) (err error) {
+  out, err := os.OpenFile(outputPath, os.O_RDWR | os.O_TRUNC | os.O_CREATE, 0644)
+  if err != nil { return err }
+
+  defer func() {
+    cerr := out.Close()
+    if err == nil {
+      err = cerr
+    }
+  }()
+
+  if _, err = payloadFile.Seek(0, io.SeekStart); err != nil { return err }
+
+  for _, part := range headers {
+    if _, err = out.Write(part); err != nil { return err }
+  }
+
+  if _, err = io.Copy(out, payloadFile); err != nil { return err }
+  return out.Sync()
+}
+
+func rpmArchitecture(machine elf.Machine) string {
+  switch machine {
+  case elf.EM_X86_64: return "x86_64"
+  case elf.EM_386: return "i686"
+  case elf.EM_AARCH64: return "aarch64"
+  case elf.EM_ARM: return "armv7hl"
+  case elf.EM_PPC64: return "ppc64le"
+  case elf.EM_S390: return "s390x"
+  }
+
+  return strings.ToLower(strings.TrimPrefix(machine.String(), "EM_"))
+}
+
+// legacy lead is still required at the start of the file
+func rpmLead(name string, machine elf.Machine) []byte {
+  lead := make([]byte, rpmLeadSize)
+  copy(lead, []byte{0xed, 0xab, 0xee, 0xdb, 3, 0})
+
+  var archnum uint16 = 1
+  switch machine {
+  case elf.EM_ARM: archnum = 12
+  case elf.EM_AARCH64: archnum = 19
+  }
+
+  binary.BigEndian.PutUint16(lead[6:], 0) // binary package
+  binary.BigEndian.PutUint16(lead[8:], archnum)
+  if len(name) > 65 { name = name[:65] }
+  copy(lead[10:76], name)
+  binary.BigEndian.PutUint16(lead[76:], 1) // linux
+  binary.BigEndian.PutUint16(lead[78:], 5) // header-style signature
+
+  return lead
+}
+
+func (ad *AppDeployer) collectRpmFiles(installRoot string, extraFiles []packageExtraFile) ([]rpmFile, error) {
+  files := make([]rpmFile, 0, 100)
+
+  err := filepath.Walk(ad.destinationRoot, func(fullpath string, info os.FileInfo, err error) error {
+    if err != nil {
+      return err
+    }
+
+    relativePath, err := filepath.Rel(ad.destinationRoot, fullpath)
+    if err != nil { return err }
+
+    file := rpmFile{
+      archivePath: path.Join(installRoot, filepath.ToSlash(relativePath)),
+      sourcePath: fullpath,
+      mode: unixMode(info.Mode()),
+      size: info.Size(),
+      mtime: info.ModTime(),
+    }
+
+    switch {
+    case info.IsDir():
+      file.size = 4096
+    case info.Mode() & os.ModeSymlink != 0:
+      if file.link, err = os.Readlink(fullpath); err != nil { return err }
+      file.size = int64(len(file.link))
+    case info.Mode().IsRegular():
+      if file.size > rpmMaxFileSize {
+        return fmt.Errorf("File %v is too big for RPM payload (%v bytes)", fullpath, file.size)
+      }
+      sum, err := md5File(fullpath)
+      if err != nil { return err }
+      file.digest = hex.EncodeToString(sum)
+    default:
+      return nil
+    }
+
+    files = append(files, file)
+    return nil
+  })
+
+  now := time.Now()
+  for _, extra := range extraFiles {
+    file := rpmFile{
+      archivePath: extra.path,
+      contents: extra.contents,
+      link: extra.link,
+      mtime: now,
+    }
+
+    if len(extra.link) > 0 {
+      file.mode = 0120777
+      file.size = int64(len(extra.link))
+    } else {
+      file.mode = 0100644
+      file.size = int64(len(extra.contents))
+      file.digest = fmt.Sprintf("%x", md5.Sum(extra.contents))
+    }
+
+    files = append(files, file)
+  }
+
+  return files, err
+}
+
+func unixMode(mode os.FileMode) uint32 {
+  result := uint32(mode.Perm())
+
+  switch {
+  case mode.IsDir(): result |= 0040000
+  case mode & os.ModeSymlink != 0: result |= 0120000
+  default: result |= 0100000
+  }
+
+  return result
+}
+
+func rpmMainHeader(metadata PackageMetadata, arch string, files []rpmFile) *rpmHeader {
+  h := &rpmHeader{}
+
+  summary := strings.SplitN(strings.TrimSpace(metadata.Description), "\n", 2)[0]
+  buildHost, _ := os.Hostname()
+  if len(buildHost) == 0 { buildHost = "localhost" }
+
+  h.addStringArray(RPMTAG_HEADERI18NTABLE, []string{"C"})
+  h.addString(RPMTAG_NAME, metadata.Name)
+  h.addString(RPMTAG_VERSION, metadata.Version)
+  h.addString(RPMTAG_RELEASE, metadata.Release)
+  h.addI18NString(RPMTAG_SUMMARY, summary)
+  h.addI18NString(RPMTAG_DESCRIPTION, metadata.Description)
+  h.addInt32(RPMTAG_BUILDTIME, uint32(time.Now().Unix()))
+  h.addString(RPMTAG_BUILDHOST, buildHost)
+  h.addString(RPMTAG_LICENSE, metadata.License)
+  h.addString(RPMTAG_PACKAGER, metadata.Maintainer)
+  h.addI18NString(RPMTAG_GROUP, "Applications/System")
+  if len(metadata.Homepage) > 0 {
+    h.addString(RPMTAG_URL, metadata.Homepage)
+  }
+  h.addString(RPMTAG_OS, "linux")
+  h.addString(RPMTAG_ARCH, arch)
+  h.addString(RPMTAG_SOURCERPM, fmt.Sprintf("%s-%s-%s.src.rpm", metadata.Name, metadata.Version, metadata.Release))
+  h.addString(RPMTAG_RPMVERSION, "4.11.3")
+  h.addString(RPMTAG_PAYLOADFORMAT, "cpio")
+  h.addString(RPMTAG_PAYLOADCOMPRESSOR, "gzip")
+  h.addString(RPMTAG_PAYLOADFLAGS, "9")
+
+  fullVersion := fmt.Sprintf("%s-%s", metadata.Version, metadata.Release)
+  h.addStringArray(RPMTAG_PROVIDENAME, []string{metadata.Name, fmt.Sprintf("%s(%s)", metadata.Name, arch)})
+  h.addInt32(RPMTAG_PROVIDEFLAGS, RPMSENSE_EQUAL, RPMSENSE_EQUAL)
+  h.addStringArray(RPMTAG_PROVIDEVERSION, []string{fullVersion, fullVersion})
+
+  var totalSize int64 = 0
+  for _, file := range files {
+    if file.mode & 0170000 == 0100000 { totalSize += file.size }
+  }
+
+  rpmlibFlags := uint32(RPMSENSE_RPMLIB | RPMSENSE_LESS | RPMSENSE_EQUAL)
+  requireNames := []string{"rpmlib(CompressedFileNames)", "rpmlib(PayloadFilesHavePrefix)"}
+  requireVersions := []string{"3.0.4-1", "4.0-1"}
+  if totalSize > rpmMaxFileSize {
+    // rpm requires this for packages with 64-bit sizes
+    requireNames = append(requireNames, "rpmlib(LargeFiles)")
+    requireVersions = append(requireVersions, "4.12.0-1")
+  }
+  requireFlags := make([]uint32, len(requireNames))
+  for i := range requireFlags {
+    requireFlags[i] = rpmlibFlags
+  }
+  h.addStringArray(RPMTAG_REQUIRENAME, requireNames)
+  h.addInt32(RPMTAG_REQUIREFLAGS, requireFlags...)
+  h.addStringArray(RPMTAG_REQUIREVERSION, requireVersions)
+
+  count := len(files)
+  sizes := make([]uint32, count)
+  modes := make([]uint16, count)
+  rdevs := make([]uint16, count)
+  mtimes := make([]uint32, count)
+  digests := make([]string, count)
+  links := make([]string, count)
+  flags := make([]uint32, count)
+  users := make([]string, count)
+  groups := make([]string, count)
+  verifyFlags := make([]uint32, count)
+  devices := make([]uint32, count)
+  inodes := make([]uint32, count)
+  langs := make([]string, count)
+  dirIndexes := make([]uint32, count)
+  basenames := make([]string, count)
+  dirnames := make([]string, 0, 10)
+  dirnameIndex := make(map[string]uint32)
+
+  for i, file := range files {
+    sizes[i] = uint32(file.size)
+    modes[i] = uint16(file.mode)
+    mtimes[i] = uint32(file.mtime.Unix())
+    digests[i] = file.digest
+    links[i] = file.link
+    users[i] = "root"
+    groups[i] = "root"
+    verifyFlags[i] = 0xffffffff
+    devices[i] = 1
+    inodes[i] = uint32(i + 1)
+
+    dirname := path.Dir(file.archivePath) + "/"
+    index, ok := dirnameIndex[dirname]
+    if !ok {
+      index = uint32(len(dirnames))
+      dirnameIndex[dirname] = index
+      dirnames = append(dirnames, dirname)
+    }
+
+    dirIndexes[i] = index
+    basenames[i] = path.Base(file.archivePath)
+  }
+
+  h.addSize(RPMTAG_SIZE, RPMTAG_LONGSIZE, totalSize)
+  h.addInt32(RPMTAG_FILESIZES, sizes...)
+  h.addInt16(RPMTAG_FILEMODES, modes...)
+  h.addInt16(RPMTAG_FILERDEVS, rdevs...)
+  h.addInt32(RPMTAG_FILEMTIMES, mtimes...)
+  h.addStringArray(RPMTAG_FILEDIGESTS, digests)
+  h.addStringArray(RPMTAG_FILELINKTOS, links)
+  h.addInt32(RPMTAG_FILEFLAGS, flags...)
+  h.addStringArray(RPMTAG_FILEUSERNAME, users)
+  h.addStringArray(RPMTAG_FILEGROUPNAME, groups)
+  h.addInt32(RPMTAG_FILEVERIFYFLAGS, verifyFlags...)
+  h.addInt32(RPMTAG_FILEDEVICES, devices...)
+  h.addInt32(RPMTAG_FILEINODES, inodes...)
+  h.addStringArray(RPMTAG_FILELANGS, langs)
+  h.addInt32(RPMTAG_DIRINDEXES, dirIndexes...)
+  h.addStringArray(RPMTAG_BASENAMES, basenames)
+  h.addStringArray(RPMTAG_DIRNAMES, dirnames)
+  h.addInt32(RPMTAG_FILEDIGESTALGO, rpmDigestAlgoMD5)
+
+  return h
+}
+
+// writes gzipped cpio (newc) archive and returns its uncompressed size
+func writeRpmPayload(w io.Writer, files []rpmFile) (int64, error) {
+  gw := gzip.NewWriter(w)
+  counter := &countingWriter{w: gw}
+
+  for i, file := range files {
+    if err := writeRpmPayloadFile(counter, file, uint32(i + 1)); err != nil { return 0, err }
+  }
+
+  if err := writeCpioEntry(counter, "TRAILER!!!", 0, 0, 1, time.Unix(0, 0), 0, nil); err != nil { return 0, err }
+
+  if err := gw.Close(); err != nil { return 0, err }
+  return counter.count, nil
+}
+
+func writeRpmPayloadFile(w *countingWriter, file rpmFile, inode uint32) error {
+  var contents io.Reader = bytes.NewReader(file.contents)
+  size := file.size
+  nlink := 1
+
+  switch file.mode & 0170000 {
+  case 0040000:
+    size = 0
+    nlink = 2
+  case 0120000:
+    contents = strings.NewReader(file.link)
+  default:
+    if len(file.sourcePath) > 0 {
+      f, err := os.Open(file.sourcePath)
+      if err != nil { return err }
+      defer f.Close()
+      contents = f
+    }
+  }
+
+  return writeCpioEntry(w, "." + file.archivePath, inode, file.mode, nlink, file.mtime, size, contents)
+}
+
+func writeCpioEntry(w *countingWriter, name string, inode, mode uint32, nlink int, mtime time.Time, size int64, contents io.Reader) error {
+  if size > rpmMaxFileSize { return fmt.Errorf("Entry %v is too big for cpio (%v bytes)", name, size) }
+
+  header := fmt.Sprintf("070701%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x",
+    inode, mode, 0, 0, nlink, mtime.Unix(), size, 0, 0, 0, 0, len(name) + 1, 0)
+
+  if _, err := io.WriteString(w, header + name + "\x00"); err != nil { return err }
+  if err := w.pad(4); err != nil { return err }
+
+  if size > 0 {
+    written, err := io.CopyN(w, contents, size)
+    if err != nil { return err }
+    if written != size { return fmt.Errorf("Short write of %v", name) }
+  }
+
+  return w.pad(4)
+}
+
+type countingWriter struct {
+  w io.Writer
+  count int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+  n, err := cw.w.Write(p)
+  cw.count += int64(n)
+  return n, err
+}
+
+func (cw *countingWriter) pad(alignment int64) error {
+  if remainder := cw.count % alignment; remainder != 0 {
+    _, err := cw.Write(make([]byte, alignment - remainder))
+    return err
+  }
+  return nil
+}
@@ -0,0 +1,146 @@
+/*
+ * This file is a part of linuxdeploy - tool for
+ * creating standalone applications for Linux
+ *
+ * Copyright (C) 2017 Taras Kushnir <kushnirTV@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the MIT License.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ */
+
+package main
+
+import (
+  "log"
+  "os"
+  "strings"
+  "debug/elf"
+  "path/filepath"
+)
+
+// describes libraries loaded with dlopen() which ldd cannot see
+type RuntimeDependencyRule struct {
+  Soname string `json:"soname"` // prefix of the SONAME, e.g. "libgstreamer-1.0.so"
+  Libraries []string `json:"libraries,omitempty"` // relative to the library dir, deployed to lib/
+  Dirs []string `json:"dirs,omitempty"` // with loadable modules, relative to the library dir
+  Host bool `json:"host,omitempty"` // library must come from the host together with its modules
+  Reason string `json:"reason,omitempty"`
+}
+
+var builtinRuntimeDependencyRules = []RuntimeDependencyRule {
+  { Soname: "libgstreamer-1.0.so", Dirs: []string{ "gstreamer-1.0" },
+    Reason: "QtMultimedia GStreamer backend loads GStreamer plugins" },
+  { Soname: "libpulse.so", Dirs: []string{ "pulseaudio" },
+    Reason: "PulseAudio client loads libpulsecommon from its private dir" },
+  { Soname: "libnss3.so",
+    Libraries: []string{ "libsoftokn3.so", "libfreebl3.so", "libfreeblpriv3.so", "libnssckbi.so", "libnssdbm3.so",
+      "nss/libsoftokn3.so", "nss/libfreebl3.so", "nss/libfreeblpriv3.so", "nss/libnssckbi.so", "nss/libnssdbm3.so" },
+    Reason: "NSS used by QtWebEngine loads its crypto modules" },
+  { Soname: "libEGL.so", Host: true,
+    Reason: "xcbglintegrations need EGL vendor library matching the host drivers" },
+  { Soname: "libGLX.so", Host: true,
+    Reason: "xcbglintegrations need GLX vendor library matching the host drivers" },
+  { Soname: "libGLdispatch.so", Host: true,
+    Reason: "libglvnd dispatches to the drivers of the host" },
+  { Soname: "libOpenGL.so", Host: true,
+    Reason: "libglvnd dispatches to the drivers of the host" },
+  { Soname: "libgtk-3.so", Host: true,
+    Reason: "gtk3 platformtheme has to use GTK modules, themes and settings of the host" },
+  { Soname: "libgdk-3.so", Host: true,
+    Reason: "gtk3 platformtheme has to use GTK modules, themes and settings of the host" },
+  { Soname: "libnss_", Host: true,
+    Reason: "glibc loads NSS modules configured on the host" },
+  { Soname: "libgio-2.0.so", Host: true,
+    Reason: "GIO modules of the host are built against GLib of the host" },
+}
+
+func (rule *RuntimeDependencyRule) matches(soname string) bool {
+  return strings.HasPrefix(soname, rule.Soname)
+}
+
+// rules from the project config replace built-in rules for the same SONAME
+func mergeRuntimeDependencyRules(builtin, custom []RuntimeDependencyRule) []RuntimeDependencyRule {
+  rules := make([]RuntimeDependencyRule, len(builtin))
+  copy(rules, builtin)
+
+  for _, customRule := range custom {
+    found := false
+
+    for i := range rules {
+      if rules[i].Soname == customRule.Soname {
+        rules[i] = customRule
+        found = true
+      }
+    }
+
+    if !found {
+      rules = append(rules, customRule)
+    }
+  }
+
+  return rules
+}
+
+// reads DT_SONAME and falls back to the file name
+func librarySoname(libpath string) string {
+  if f, err := elf.Open(libpath); err == nil {
+    defer f.Close()
+
+    if sonames, err := f.DynString(elf.DT_SONAME); err == nil && len(sonames) > 0 {
+      return sonames[0]
+    }
+  }
+
+  return filepath.Base(libpath)
+}
+
+func (ad *AppDeployer) findRuntimeDependencyRules(libpath string) []RuntimeDependencyRule {
+  soname := librarySoname(libpath)
+  rules := make([]RuntimeDependencyRule, 0)
+
+  for _, rule := range ad.runtimeRules {
+    if rule.matches(soname) {
+      rules = append(rules, rule)
+    }
+  }
+
+  return rules
+}
+
+// returns false if library has to be left to the host
+func (ad *AppDeployer) applyRuntimeDependencyRules(libpath string) bool {
+  libdir := filepath.Dir(libpath)
+
+  for _, rule := range ad.findRuntimeDependencyRules(libpath) {
+    if rule.Host {
+      log.Printf("Leaving %v to the host: %v", libpath, rule.Reason)
+      ad.report.accountHostLibrary(libpath, rule.Reason)
+      return false
+    }
+
+    log.Printf("Applying runtime dependency rule %v to %v: %v", rule.Soname, libpath, rule.Reason)
+
+    for _, library := range rule.Libraries {
+      companionPath := filepath.Join(libdir, library)
+      if _, err := os.Stat(companionPath); err != nil { continue }
+
+      log.Printf("Deploying runtime dependency %v of %v", companionPath, libpath)
+      ad.addLibTask("", companionPath, "lib", LDD_AND_RPATH_FLAG)
+    }
+
+    for _, dir := range rule.Dirs {
+      if _, err := os.Stat(filepath.Join(libdir, dir)); err != nil {
+        log.Printf("Runtime dependencies dir %v is not found in %v", dir, libdir)
+        continue
+      }
+
+      ad.deployRecursively(libdir, dir, "lib", LDD_AND_RPATH_FLAG)
+    }
+  }
+
+  return true
+}
@@ -0,0 +1,192 @@
+/*
+ * This file is a part of linuxdeploy - tool for
+ * creating standalone applications for Linux
+ *
+ * Copyright (C) 2017 Taras Kushnir <kushnirTV@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the MIT License.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ */
+
+package main
+
+import (
+  "log"
+  "os"
+  "io"
+  "fmt"
+  "strings"
+  "archive/tar"
+  "io/ioutil"
+  "path/filepath"
+)
+
+const selfExtractHeader = `#!/bin/sh
+# self-extracting installer generated by linuxdeploy
+APP="@APP@"
+TARGET="${HOME}/.local/opt/@PACKAGE@"
+LIST=0
+NOEXEC=0
+
+usage() {
+  echo "Usage: $0 [--target DIR] [--list] [--noexec]"
+  echo "  --target DIR  extract to DIR (default is $TARGET)"
+  echo "  --list        list contents of the installer"
+  echo "  --noexec      only extract files, do not run post-extract hook"
+}
+
+while [ $# -gt 0 ]; do
+  case "$1" in
+    --target)
+      if [ $# -lt 2 ]; then
+        echo "Option --target requires a directory"
+        usage
+        exit 1
+      fi
+      TARGET="$2"; shift 2 ;;
+    --target=*) TARGET="${1#--target=}"; shift ;;
+    --list) LIST=1; shift ;;
+    --noexec) NOEXEC=1; shift ;;
+    -h|--help) usage; exit 0 ;;
+    *) echo "Unknown option: $1"; usage; exit 1 ;;
+  esac
+done
+
+payload() {
+  tail -n +@PAYLOAD_LINE@ "$0"
+}
+
+if [ "$LIST" = "1" ]; then
+  payload | tar -tzf -
+  exit $?
+fi
+
+if [ -z "$TARGET" ]; then
+  echo "Target directory is empty"
+  exit 1
+fi
+
+mkdir -p "$TARGET" || exit 1
+payload | tar -xzf - -C "$TARGET" || exit 1
+TARGET="$(cd "$TARGET" && pwd)"
+
+post_extract() {
+@HOOK@
+}
+
+if [ "$NOEXEC" = "0" ]; then
+  post_extract
+fi
+
+echo "Installed to $TARGET"
+echo "Run it with $TARGET/bin/$APP"
+exit 0
+`
+
+// target path is escaped for the quoted Exec value and then for sed replacement
+const selfExtractDesktopHook = `  DATA_HOME="${XDG_DATA_HOME:-$HOME/.local/share}"
+  case "$TARGET" in
+    *'
+'*) echo "Desktop file is not installed: target path contains a newline"; return ;;
+  esac
+  EXEC_PATH="$(printf '%s\n' "$TARGET/bin/$APP" | sed -e 's/\\/\\\\\\\\/g' -e 's/["$` + "`" + `]/\\\\&/g' -e 's/[\\|&]/\\&/g')"
+  if [ -f "$TARGET/@DESKTOP@" ]; then
+    mkdir -p "$DATA_HOME/applications"
+    sed -e "s|^Exec=.*|Exec=\"$EXEC_PATH\" %F|" -e "s|^Icon=.*|Icon=@PACKAGE@|" \
+      "$TARGET/@DESKTOP@" > "$DATA_HOME/applications/@PACKAGE@.desktop"
+    echo "Installed desktop file to $DATA_HOME/applications/@PACKAGE@.desktop"
+  fi
+  if [ -n "@ICON@" ] && [ -f "$TARGET/@ICON@" ]; then
+    mkdir -p "$DATA_HOME/icons/hicolor/@ICON_SIZE@/apps"
+    cp "$TARGET/@ICON@" "$DATA_HOME/icons/hicolor/@ICON_SIZE@/apps/@PACKAGE@@ICON_EXT@"
+  fi
+  if command -v update-desktop-database >/dev/null 2>&1; then
+    update-desktop-database "$DATA_HOME/applications" >/dev/null 2>&1
+  fi`
+
+func (ad *AppDeployer) createSelfExtractingInstaller() error {
+  metadata := ad.packageMetadata()
+
+  launcherPath := filepath.Join("bin", ad.appName())
+  if err := ad.generateLauncherScript(launcherPath); err != nil { return err }
+
+  hook := "  :"
+  if *runDesktopHookFlag {
+    desktopFilename := ad.appName() + ".desktop"
+    if _, err := os.Stat(filepath.Join(ad.destinationRoot, desktopFilename)); err != nil {
+      ad.generateDesktopFile()
+    }
+
+    iconSize, iconExtension := "", ""
+    if len(ad.iconFilename) > 0 {
+      iconSize = iconThemeSize(filepath.Join(ad.destinationRoot, ad.iconFilename))
+      iconExtension = strings.ToLower(filepath.Ext(ad.iconFilename))
+    }
+
+    hook = strings.NewReplacer(
+      "@DESKTOP@", desktopFilename,
+      "@ICON@", ad.iconFilename,
+      "@ICON_SIZE@", iconSize,
+      "@ICON_EXT@", iconExtension,
+      "@PACKAGE@", metadata.Name,
+    ).Replace(selfExtractDesktopHook)
+  }
+
+  header := strings.NewReplacer(
+    "@APP@", ad.appName(),
+    "@PACKAGE@", metadata.Name,
+    "@HOOK@", hook,
+  ).Replace(selfExtractHeader)
+  header = strings.Replace(header, "@PAYLOAD_LINE@", fmt.Sprintf("%d", strings.Count(header, "\n") + 1), 1)
+
+  outputPath := ad.packageOutputPath(fmt.Sprintf("%s-%s.run", metadata.Name, metadata.Version))
+  log.Printf("Creating self-extracting installer %v", outputPath)
+
+  payloadFile, err := ioutil.TempFile(filepath.Dir(outputPath), ".linuxdeploy-payload-")
+  if err != nil { return err }
+  payloadPath := payloadFile.Name()
+  payloadFile.Close()
+  defer os.Remove(payloadPath)
+
+  err = writeCompressedFile(payloadPath, "gzip", func(w io.Writer) error {
+    tw := tar.NewWriter(w)
+    if err := addTreeToTar(tw, ad.destinationRoot, "."); err != nil { return err }
+    return tw.Close()
+  })
+  if err != nil { return err }
+
+  if err = writeSelfExtractingFile(outputPath, header, payloadPath); err != nil {
+    os.Remove(outputPath)
+    return err
+  }
+
+  log.Printf("Self-extracting installer created at %v", outputPath)
+  return nil
+}
+
+func writeSelfExtractingFile(outputPath, header, payloadPath string) (err error) {
+  out, err := os.OpenFile(outputPath, os.O_RDWR | os.O_TRUNC | os.O_CREATE, 0755)
+  if err != nil { return err }
+
+  defer func() {
+    cerr := out.Close()
+    if err == nil {
+      err = cerr
+    }
+  }()
+
+  if _, err = io.WriteString(out, header); err != nil { return err }
+
+  payload, err := os.Open(payloadPath)
+  if err != nil { return err }
+  defer payload.Close()
+
+  if _, err = io.Copy(out, payload); err != nil { return err }
+  if err = out.Chmod(0755); err != nil { return err }
+
+  return out.Sync()
+}
@@ -0,0 +1,183 @@
+/*
+ * This file is a part of linuxdeploy - tool for
+ * creating standalone applications for Linux
+ *
+ * Copyright (C) 2017 Taras Kushnir <kushnirTV@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the MIT License.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ */
+
+package main
+
+import (
+  "os"
+  "fmt"
+  "sort"
+  "errors"
+  "strconv"
+  "strings"
+  "path/filepath"
+  "encoding/json"
+  "io/ioutil"
+)
+
+const (
+  largestEntriesCount = 15
+)
+
+type SizeEntry struct {
+  Path string `json:"path"`
+  Size int64 `json:"size"`
+}
+
+type SizeBreakdown struct {
+  Total int64 `json:"total"`
+  Categories map[string]int64 `json:"categories"`
+  Largest []SizeEntry `json:"largest"`
+  Files map[string]int64 `json:"files"`
+}
+
+func analyzeSizes(root string) (*SizeBreakdown, error) {
+  sb := &SizeBreakdown{
+    Categories: make(map[string]int64),
+    Files: make(map[string]int64),
+  }
+
+  err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+    if err != nil {
+      return err
+    }
+
+    if !info.Mode().IsRegular() {
+      return nil
+    }
+
+    relativePath, err := filepath.Rel(root, path)
+    if err != nil { return err }
+
+    size := info.Size()
+    sb.Total += size
+    sb.Files[relativePath] = size
+    sb.Categories[sizeCategory(relativePath)] += size
+
+    return nil
+  })
+
+  sb.Largest = largestEntries(sb.Files, largestEntriesCount)
+
+  return sb, err
+}
+
+func sizeCategory(relativePath string) string {
+  parts := strings.Split(filepath.ToSlash(relativePath), "/")
+  topDir := parts[0]
+
+  if len(parts) == 1 {
+    return "other"
+  }
+
+  switch topDir {
+  case "lib":
+    if strings.HasPrefix(strings.ToLower(parts[1]), "libqt") {
+      return "Qt libraries"
+    }
+    return "system libraries"
+  case "plugins":
+    if len(parts) > 2 {
+      return "Qt plugins: " + parts[1]
+    }
+    return "Qt plugins"
+  case "qml":
+    return "QML modules"
+  case "resources", "libexecs":
+    return "WebEngine resources"
+  case "translations":
+    if parts[1] == "qtwebengine_locales" {
+      return "WebEngine resources"
+    }
+    return "translations"
+  }
+
+  return "other"
+}
+
+func largestEntries(sizes map[string]int64, count int) []SizeEntry {
+  entries := make([]SizeEntry, 0, len(sizes))
+  for path, size := range sizes {
+    entries = append(entries, SizeEntry{Path: path, Size: size})
+  }
+
+  sort.Slice(entries, func(i, j int) bool {
+    if entries[i].Size == entries[j].Size { return entries[i].Path < entries[j].Path }
+    return entries[i].Size > entries[j].Size
+  })
+
+  if len(entries) > count {
+    entries = entries[:count]
+  }
+
+  return entries
+}
+
+// files that are new or grew compared to the baseline, by growth
+func (sb *SizeBreakdown) newAdditions(baseline map[string]int64, count int) []SizeEntry {
+  growth := make(map[string]int64)
+
+  for path, size := range sb.Files {
+    previousSize, _ := baseline[path]
+    if size > previousSize {
+      growth[path] = size - previousSize
+    }
+  }
+
+  return largestEntries(growth, count)
+}
+
+func (sb *SizeBreakdown) sortedCategories() []SizeEntry {
+  return largestEntries(sb.Categories, len(sb.Categories))
+}
+
+// reads sizes of files from the report of a previous deployment
+func loadBaselineSizes(reportPath string) (map[string]int64, error) {
+  data, err := ioutil.ReadFile(reportPath)
+  if err != nil { return nil, err }
+
+  var baseline struct {
+    Sizes *SizeBreakdown `json:"sizes"`
+  }
+
+  if err = json.Unmarshal(data, &baseline); err != nil { return nil, err }
+  if baseline.Sizes == nil { return nil, errors.New("Report does not contain size information") }
+
+  return baseline.Sizes.Files, nil
+}
+
+// parses sizes like 1024, 500K, 200M or 1G
+func parseSize(value string) (int64, error) {
+  value = strings.ToUpper(strings.TrimSpace(value))
+  value = strings.TrimSuffix(strings.TrimSuffix(value, "B"), "I")
+  if len(value) == 0 { return 0, errors.New("Empty size") }
+
+  var multiplier int64 = 1
+
+  switch value[len(value) - 1] {
+  case 'K': multiplier = 1 << 10
+  case 'M': multiplier = 1 << 20
+  case 'G': multiplier = 1 << 30
+  }
+
+  if multiplier != 1 {
+    value = value[:len(value) - 1]
+  }
+
+  number, err := strconv.ParseFloat(value, 64)
+  if err != nil { return 0, err }
+  if number < 0 { return 0, fmt.Errorf("Negative size %v", value) }
+
+  return int64(number * float64(multiplier)), nil
+}
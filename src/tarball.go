@@ -0,0 +1,141 @@
+/*
+ * This file is a part of linuxdeploy - tool for
+ * creating standalone applications for Linux
+ *
+ * Copyright (C) 2017 Taras Kushnir <kushnirTV@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the MIT License.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ */
+
+package main
+
+import (
+  "log"
+  "os"
+  "io"
+  "fmt"
+  "archive/tar"
+  "compress/gzip"
+  "path"
+  "path/filepath"
+  "strings"
+)
+
+var tarballCompressions = map[string]string {
+  "gzip": ".tar.gz",
+  "xz": ".tar.xz",
+  "none": ".tar",
+}
+
+func (ad *AppDeployer) createTarball() error {
+  launcherPath := filepath.Join("bin", ad.appName())
+  if err := ad.generateLauncherScript(launcherPath); err != nil { return err }
+
+  suffix := tarballCompressions[*tarballCompressionFlag]
+  outputPath := ad.outputPath(*tarballOutputFlag, suffix)
+
+  rootName := *tarballRootFlag
+  if len(rootName) == 0 { rootName = ad.appName() }
+
+  log.Printf("Creating tarball %v with root directory %v", outputPath, rootName)
+
+  err := writeCompressedFile(outputPath, *tarballCompressionFlag, func(w io.Writer) error {
+    tw := tar.NewWriter(w)
+
+    if err := addTreeToTar(tw, ad.destinationRoot, rootName); err != nil { return err }
+
+    return tw.Close()
+  })
+
+  if err != nil {
+    os.Remove(outputPath)
+    return err
+  }
+
+  log.Printf("Tarball created at %v", outputPath)
+  return nil
+}
+
+// writes file through the compressor without external tools
+func writeCompressedFile(outputPath, compression string, writeContents func(io.Writer) error) (err error) {
+  out, err := os.OpenFile(outputPath, os.O_RDWR | os.O_TRUNC | os.O_CREATE, 0644)
+  if err != nil { return err }
+
+  defer func() {
+    cerr := out.Close()
+    if err == nil {
+      err = cerr
+    }
+  }()
+
+  switch compression {
+  case "none":
+    return writeContents(out)
+  case "gzip":
+    gw := gzip.NewWriter(out)
+    if err = writeContents(gw); err != nil { return err }
+    return gw.Close()
+  case "xz":
+    xw := newXzWriter(out)
+    if err = writeContents(xw); err != nil { return err }
+    return xw.Close()
+  }
+
+  return fmt.Errorf("Unsupported compression %v", compression)
+}
+
+// adds all files from the sourceRoot under archivePrefix preserving symlinks and modes
+func addTreeToTar(tw *tar.Writer, sourceRoot, archivePrefix string) error {
+  return filepath.Walk(sourceRoot, func(fullpath string, info os.FileInfo, err error) error {
+    if err != nil {
+      return err
+    }
+
+    relativePath, err := filepath.Rel(sourceRoot, fullpath)
+    if err != nil { return err }
+
+    archivePath := path.Join(archivePrefix, filepath.ToSlash(relativePath))
+    // Debian data archives name entries "./opt/app/..." and path.Join drops
+    // the leading ./; tarball roots are plain dir names and are not affected
+    if strings.HasPrefix(archivePrefix, "./") {
+      archivePath = "./" + archivePath
+    }
+    return addFileToTar(tw, fullpath, archivePath, info)
+  })
+}
+
+func addFileToTar(tw *tar.Writer, fullpath, archivePath string, info os.FileInfo) error {
+  link := ""
+  if info.Mode() & os.ModeSymlink != 0 {
+    var err error
+    if link, err = os.Readlink(fullpath); err != nil { return err }
+  }
+
+  header, err := tar.FileInfoHeader(info, link)
+  if err != nil { return err }
+
+  header.Name = archivePath
+  if info.IsDir() {
+    header.Name += "/"
+  }
+
+  header.Uid, header.Gid = 0, 0
+  header.Uname, header.Gname = "root", "root"
+  header.Format = tar.FormatPAX
+
+  if err = tw.WriteHeader(header); err != nil { return err }
+
+  if !info.Mode().IsRegular() { return nil }
+
+  f, err := os.Open(fullpath)
+  if err != nil { return err }
+  defer f.Close()
+
+  _, err = io.Copy(tw, f)
+  return err
+}
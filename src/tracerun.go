@@ -0,0 +1,186 @@
+/*
+ * This file is a part of linuxdeploy - tool for
+ * creating standalone applications for Linux
+ *
+ * Copyright (C) 2017 Taras Kushnir <kushnirTV@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the MIT License.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ */
+
+package main
+
+import (
+  "log"
+  "os"
+  "fmt"
+  "bytes"
+  "context"
+  "strings"
+  "os/exec"
+  "path/filepath"
+)
+
+const (
+  runtimeTraceOrigin = "runtime trace"
+  ldDebugInitMarker = "calling init: "
+  ldDebugTryingMarker = "trying file="
+  ldDebugLinkMapMarker = ";  generating link map"
+)
+
+// runs deployed exe and deploys libraries it loaded from outside of the AppDir,
+// returns libraries which were queued for deployment
+func (ad *AppDeployer) traceRun() []string {
+  output, err := ad.runTraced()
+  if err != nil {
+    log.Printf("Trace run failed: %v", err)
+    return nil
+  }
+
+  libraries := parseLdDebugOutput(output, ad.destinationRoot)
+  log.Printf("Trace run loaded %v libraries from outside of %v", len(libraries), ad.destinationRoot)
+
+  // processed libs are not modified while no tasks are running
+  // so all of them have to be checked before queueing any
+  qd := ad.qtDeployer
+  missing := make([]string, 0, len(libraries))
+  for _, libpath := range libraries {
+    if ad.isLibraryDeployed(libpath) { continue }
+
+    // Qt plugins have to be deployed by the Qt rules, not as libraries
+    if qd.qtEnvironmentSet && (isSubpath(libpath, qd.PluginsPath()) || isSubpath(libpath, qd.QmlPath())) {
+      log.Printf("Warning: trace run loaded %v from the Qt installation", libpath)
+      continue
+    }
+
+    missing = append(missing, libpath)
+  }
+
+  for _, libpath := range missing {
+    log.Printf("Deploying %v found by trace run", libpath)
+    ad.addLibTask("", libpath, "lib", LDD_AND_RPATH_FLAG)
+  }
+
+  return missing
+}
+
+// reports only libraries which were not left to the host or blacklisted
+func (ad *AppDeployer) accountTracedLibraries(libraries []string) {
+  for _, libpath := range libraries {
+    if _, err := os.Stat(filepath.Join(ad.LibsPath(), filepath.Base(libpath))); err != nil {
+      log.Printf("Library %v found by trace run was not deployed", libpath)
+      continue
+    }
+
+    ad.report.accountExtraLibrary(libpath, runtimeTraceOrigin)
+  }
+}
+
+// returns stderr of the exe run with LD_DEBUG in the clean environment
+func (ad *AppDeployer) runTraced() ([]byte, error) {
+  exePath := filepath.Join(ad.destinationRoot, filepath.Base(ad.targetExePath))
+
+  ctx, cancel := context.WithTimeout(context.Background(), *traceTimeoutFlag)
+  defer cancel()
+
+  cmd := exec.CommandContext(ctx, exePath, strings.Fields(*traceArgsFlag)...)
+  cmd.Dir = ad.destinationRoot
+  cmd.Env = ad.traceRunEnvironment()
+
+  var stderr bytes.Buffer
+  cmd.Stderr = &stderr
+
+  log.Printf("Starting trace run of %v with timeout %v", exePath, *traceTimeoutFlag)
+  err := cmd.Run()
+
+  if ctx.Err() == context.DeadlineExceeded {
+    // GUI apps usually do not exit on their own
+    log.Printf("Trace run was stopped after %v", *traceTimeoutFlag)
+  } else if err != nil {
+    if _, ok := err.(*exec.ExitError); !ok { return nil, err }
+    log.Printf("Trace run finished with %v", err)
+  }
+
+  return stderr.Bytes(), nil
+}
+
+// only variables of deployed launcher and the ones required for tracing
+func (ad *AppDeployer) traceRunEnvironment() []string {
+  env := []string{
+    "PATH=/usr/local/bin:/usr/bin:/bin",
+    "HOME=" + os.Getenv("HOME"),
+    "APPDIR=" + ad.destinationRoot,
+    "LD_DEBUG=libs,files",
+    "QT_QPA_PLATFORM=offscreen",
+  }
+
+  for _, variable := range ad.deployedLauncherVariables() {
+    value := filepath.Join(ad.destinationRoot, variable.relativePath)
+    if len(variable.fallback) > 0 { value += ":" + variable.fallback }
+    env = append(env, fmt.Sprintf("%s=%s", variable.name, value))
+  }
+
+  for name, value := range ad.config.Environment {
+    env = append(env, fmt.Sprintf("%s=%s", name, strings.Replace(value, "$APPDIR", ad.destinationRoot, -1)))
+  }
+
+  return env
+}
+
+// lines look like "     12345:	calling init: /usr/lib/libfoo.so.1", libraries
+// without init functions are found by "file=libfoo.so.1 [0];  generating link map"
+// which follows the successful "trying file=/usr/lib/libfoo.so.1" of the same process
+func parseLdDebugOutput(output []byte, appDirPath string) []string {
+  libraries := make([]string, 0, 10)
+  seen := make(map[string]bool)
+  lastTried := make(map[string]string)
+
+  for _, line := range strings.Split(string(output), "\n") {
+    separatorIndex := strings.Index(line, ":")
+    if separatorIndex == -1 { continue }
+
+    pid := strings.TrimSpace(line[:separatorIndex])
+    message := strings.TrimSpace(line[separatorIndex + 1:])
+    libpath := ""
+
+    switch {
+    case strings.HasPrefix(message, ldDebugInitMarker):
+      libpath = strings.TrimSpace(message[len(ldDebugInitMarker):])
+    case strings.HasPrefix(message, ldDebugTryingMarker):
+      lastTried[pid] = message[len(ldDebugTryingMarker):]
+      continue
+    case strings.HasPrefix(message, "file=") && strings.HasSuffix(message, ldDebugLinkMapMarker):
+      name := strings.TrimSuffix(message, ldDebugLinkMapMarker)
+      if index := strings.LastIndex(name, " ["); index != -1 { name = name[:index] }
+      libpath = strings.TrimPrefix(name, "file=")
+      // library was found by the search, otherwise it was opened by its path
+      if !filepath.IsAbs(libpath) { libpath = lastTried[pid] }
+      delete(lastTried, pid)
+    default:
+      continue
+    }
+
+    if !filepath.IsAbs(libpath) || seen[libpath] { continue }
+    seen[libpath] = true
+
+    if isSubpath(libpath, appDirPath) { continue }
+
+    // dynamic loader always comes from the host
+    if strings.HasPrefix(filepath.Base(libpath), "ld-linux") { continue }
+
+    libraries = append(libraries, libpath)
+  }
+
+  return libraries
+}
+
+func isSubpath(path, root string) bool {
+  if len(root) == 0 { return false }
+
+  relativePath, err := filepath.Rel(root, path)
+  return err == nil && relativePath != ".." && !strings.HasPrefix(relativePath, ".." + string(filepath.Separator))
+}
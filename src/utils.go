@@ -23,6 +23,7 @@ import (
   "errors"
   "strings"
   "bytes"
+  "fmt"
 )
 
 type Bitmask uint32
@@ -112,11 +113,11 @@ func parseLddOutputLine(line string) (string, string, error) {
   return libname, libpath, nil
 }
 
-func replaceInBuffer(buffer, key, replacement []byte) {
+// replaces zero-terminated value after the key, returns offset and previous value
+func replaceInBuffer(buffer, key, replacement []byte) (offset int, previous string, err error) {
   index := bytes.Index(buffer, key)
   if index == -1 {
-    log.Printf("Not found \"%s\" %v when replacing", key, key)
-    return
+    return -1, "", fmt.Errorf("Not found \"%s\" when replacing", key)
   }
 
   nextIndex := len(key) + index
@@ -124,15 +125,15 @@ func replaceInBuffer(buffer, key, replacement []byte) {
 
   endIndex := bytes.IndexByte(buffer[nextIndex:], byte(0))
   if endIndex == -1 {
-    log.Printf("End not found for %s (%v) when replacing", key, key)
-    return
+    return nextIndex, "", fmt.Errorf("End not found for %s when replacing", key)
   }
 
   log.Printf("Replacement End found at %v", endIndex + nextIndex)
 
+  previous = string(buffer[nextIndex:nextIndex + endIndex])
+
   if endIndex < len(replacement) {
-    log.Printf("Cannot exceed length when replacing %s", key)
-    return
+    return nextIndex, previous, fmt.Errorf("Cannot exceed length when replacing %s (%v)", key, previous)
   }
 
   i := nextIndex
@@ -140,7 +141,7 @@ func replaceInBuffer(buffer, key, replacement []byte) {
   replacementSize := len(replacement)
   endIndex += nextIndex
 
-  log.Printf("Replacement previous value is %s", buffer[nextIndex:endIndex])
+  log.Printf("Replacement previous value is %s", previous)
 
   for (i < endIndex) && (j < replacementSize) {
     buffer[i] = replacement[j]
@@ -155,8 +156,36 @@ func replaceInBuffer(buffer, key, replacement []byte) {
   }
 
   log.Printf("Replaced \"%s\" %v to \"%s\" %v", key, key, replacement, replacement)
+  return nextIndex, previous, nil
 }
 
-func replaceVariable(buffer []byte, varname, varvalue string) {
-  replaceInBuffer(buffer, []byte(varname), []byte(varvalue))
+func replaceVariable(buffer []byte, varname, varvalue string) (int, string, error) {
+  return replaceInBuffer(buffer, []byte(varname), []byte(varvalue))
+}
+
+// replaces whole zero-terminated string which equals value
+func replaceString(buffer []byte, value, replacement string) (int, string, error) {
+  // surrounding terminators make sure the whole string is matched
+  index := bytes.Index(buffer, []byte("\x00" + value + "\x00"))
+  if index == -1 {
+    return -1, "", fmt.Errorf("Not found string \"%s\" when replacing", value)
+  }
+
+  if len(value) < len(replacement) {
+    return index + 1, value, fmt.Errorf("Cannot exceed length when replacing %s", value)
+  }
+
+  // previous terminator is used as a key
+  offset, previous, err := replaceInBuffer(buffer[index:], []byte("\x00"), []byte(replacement))
+  return index + offset, previous, err
+}
+
+// reads zero-terminated string starting at offset
+func readCString(buffer []byte, offset int) string {
+  if offset < 0 || offset >= len(buffer) { return "" }
+
+  end := bytes.IndexByte(buffer[offset:], byte(0))
+  if end == -1 { return string(buffer[offset:]) }
+
+  return string(buffer[offset:offset + end])
 }
@@ -0,0 +1,583 @@
+/*
+ * This file is a part of linuxdeploy - tool for
+ * creating standalone applications for Linux
+ *
+ * Copyright (C) 2017 Taras Kushnir <kushnirTV@gmail.com>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the MIT License.
+
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ */
+
+package main
+
+import (
+  "io"
+  "bytes"
+  "errors"
+  "hash"
+  "hash/crc32"
+  "encoding/binary"
+)
+
+// minimal .xz writer: single block with LZMA2 filter and CRC32 check,
+// LZMA is encoded with greedy parsing over hash chains
+
+const (
+  xzDictSizeByte = 16 // 1 MiB, see LZMA2 dictionary size encoding
+  xzDictSize = 1 << 20
+  xzChunkSize = 1 << 16 // uncompressed bytes per LZMA2 chunk
+  xzMaxPackedSize = 1 << 16
+
+  lzmaLc = 3
+  lzmaPb = 2
+  lzmaPropsByte = (lzmaPb * 5) * 9 + lzmaLc
+  lzmaStates = 12
+  lzmaPosStates = 1 << lzmaPb
+  lzmaMinMatch = 2
+  lzmaMaxMatch = 273
+  lzmaEndPosModelIndex = 14
+  lzmaFullDistances = 128
+  lzmaAlignBits = 4
+
+  xzHashBits = 16
+  xzMaxChain = 24
+)
+
+var xzStreamMagic = []byte{ 0xFD, '7', 'z', 'X', 'Z', 0x00 }
+var xzStreamFlags = []byte{ 0x00, 0x01 } // CRC32 check
+
+type xzWriter struct {
+  w io.Writer
+  closed bool
+  headerWritten bool
+  check hash.Hash32
+  uncompressedSize uint64
+  lzma2Size uint64 // LZMA2 chunks and the end marker
+
+  window []byte // history of up to xzDictSize bytes and pending input
+  base int64 // absolute position of window[0]
+  pending int // index in window of the first not encoded byte
+  head []int64 // last absolute position + 1 for the hash
+  prev []int64 // previous position + 1 with the same hash, by window index
+
+  dictReset bool
+  propsWritten bool
+  encoder *lzmaEncoder
+}
+
+func newXzWriter(w io.Writer) *xzWriter {
+  return &xzWriter{
+    w: w,
+    check: crc32.NewIEEE(),
+    head: make([]int64, 1 << xzHashBits),
+    dictReset: true,
+    encoder: &lzmaEncoder{},
+  }
+}
+
+func (xw *xzWriter) Write(p []byte) (int, error) {
+  if xw.closed { return 0, errors.New("Write to closed xz writer") }
+
+  xw.check.Write(p)
+  xw.uncompressedSize += uint64(len(p))
+  written := len(p)
+
+  for len(p) > 0 {
+    free := xzChunkSize - (len(xw.window) - xw.pending)
+    n := len(p)
+    if n > free { n = free }
+
+    xw.window = append(xw.window, p[:n]...)
+    xw.prev = append(xw.prev, make([]int64, n)...)
+    p = p[n:]
+
+    if len(xw.window) - xw.pending == xzChunkSize {
+      if err := xw.writeChunk(); err != nil { return 0, err }
+    }
+  }
+
+  return written, nil
+}
+
+func (xw *xzWriter) Close() error {
+  if xw.closed { return nil }
+  xw.closed = true
+
+  if len(xw.window) > xw.pending {
+    if err := xw.writeChunk(); err != nil { return err }
+  }
+
+  var records []byte
+  if xw.headerWritten {
+    // end of LZMA2 data, block padding and the check
+    blockEnd := []byte{ 0x00 }
+    xw.lzma2Size++
+    for (xw.lzma2Size + uint64(len(blockEnd)) - 1) % 4 != 0 {
+      blockEnd = append(blockEnd, 0x00)
+    }
+    blockEnd = append(blockEnd, make([]byte, 4)...)
+    binary.LittleEndian.PutUint32(blockEnd[len(blockEnd) - 4:], xw.check.Sum32())
+    if _, err := xw.w.Write(blockEnd); err != nil { return err }
+
+    unpaddedSize := uint64(len(xzBlockHeader())) + xw.lzma2Size + 4
+    records = append(xzVarint(unpaddedSize), xzVarint(xw.uncompressedSize)...)
+  } else {
+    if _, err := xw.w.Write(xzStreamHeader()); err != nil { return err }
+  }
+
+  index := []byte{ 0x00 }
+  if len(records) > 0 {
+    index = append(index, xzVarint(1)...)
+    index = append(index, records...)
+  } else {
+    index = append(index, xzVarint(0)...)
+  }
+  for len(index) % 4 != 0 {
+    index = append(index, 0x00)
+  }
+  index = appendCrc32(index, index)
+  if _, err := xw.w.Write(index); err != nil { return err }
+
+  footer := make([]byte, 6)
+  binary.LittleEndian.PutUint32(footer, uint32(len(index) / 4 - 1))
+  copy(footer[4:], xzStreamFlags)
+  footer = append(appendCrc32(nil, footer), footer...)
+  footer = append(footer, 'Y', 'Z')
+
+  _, err := xw.w.Write(footer)
+  return err
+}
+
+func xzStreamHeader() []byte {
+  header := append([]byte{}, xzStreamMagic...)
+  header = append(header, xzStreamFlags...)
+  return appendCrc32(header, xzStreamFlags)
+}
+
+func xzBlockHeader() []byte {
+  // size, flags (one filter, no sizes), LZMA2 filter id, props size, dict size
+  header := []byte{ 0x02, 0x00, 0x21, 0x01, xzDictSizeByte, 0x00, 0x00, 0x00 }
+  return appendCrc32(header, header)
+}
+
+func appendCrc32(dst, data []byte) []byte {
+  value := make([]byte, 4)
+  binary.LittleEndian.PutUint32(value, crc32.ChecksumIEEE(data))
+  return append(dst, value...)
+}
+
+func xzVarint(value uint64) []byte {
+  result := make([]byte, 0, 9)
+  for value >= 0x80 {
+    result = append(result, byte(value) | 0x80)
+    value >>= 7
+  }
+
+  return append(result, byte(value))
+}
+
+// encodes pending bytes as one LZMA2 chunk or stores them if they do not compress
+func (xw *xzWriter) writeChunk() error {
+  if !xw.headerWritten {
+    xw.headerWritten = true
+    if _, err := xw.w.Write(xzStreamHeader()); err != nil { return err }
+    if _, err := xw.w.Write(xzBlockHeader()); err != nil { return err }
+  }
+
+  start, end := xw.pending, len(xw.window)
+  unpacked := end - start
+  packed := xw.encoder.encodeChunk(xw, start, end)
+
+  var chunk []byte
+  if len(packed) <= xzMaxPackedSize && len(packed) < unpacked {
+    control := byte(0xA0) // state reset
+    if xw.dictReset {
+      control = 0xE0
+    } else if !xw.propsWritten {
+      control = 0xC0
+    }
+    control |= byte((unpacked - 1) >> 16)
+
+    chunk = []byte{ control, byte((unpacked - 1) >> 8), byte(unpacked - 1), byte((len(packed) - 1) >> 8), byte(len(packed) - 1) }
+    if control >= 0xC0 {
+      chunk = append(chunk, lzmaPropsByte)
+      xw.propsWritten = true
+    }
+    chunk = append(chunk, packed...)
+  } else {
+    control := byte(0x02)
+    if xw.dictReset { control = 0x01 }
+
+    chunk = []byte{ control, byte((unpacked - 1) >> 8), byte(unpacked - 1) }
+    chunk = append(chunk, xw.window[start:end]...)
+  }
+
+  xw.dictReset = false
+  xw.lzma2Size += uint64(len(chunk))
+  if _, err := xw.w.Write(chunk); err != nil { return err }
+
+  xw.pending = end
+  xw.trimWindow()
+  return nil
+}
+
+// drops history which is out of the dictionary
+func (xw *xzWriter) trimWindow() {
+  if xw.pending < 2 * xzDictSize { return }
+
+  drop := xw.pending - xzDictSize
+  xw.window = append([]byte{}, xw.window[drop:]...)
+  xw.prev = append([]int64{}, xw.prev[drop:]...)
+  xw.base += int64(drop)
+  xw.pending -= drop
+}
+
+func (xw *xzWriter) hashAt(index int) int {
+  value := uint32(xw.window[index]) | uint32(xw.window[index + 1]) << 8 | uint32(xw.window[index + 2]) << 16
+  return int((value * 2654435761) >> (32 - xzHashBits))
+}
+
+func (xw *xzWriter) insertHash(index, end int) {
+  if index + 3 > end { return }
+
+  h := xw.hashAt(index)
+  xw.prev[index] = xw.head[h]
+  xw.head[h] = xw.base + int64(index) + 1
+}
+
+func (xw *xzWriter) matchLength(index, distance, limit int) int {
+  length := 0
+  for length < limit && xw.window[index + length] == xw.window[index - distance + length] {
+    length++
+  }
+
+  return length
+}
+
+// longest match at index with distance up to the dictionary size
+func (xw *xzWriter) findMatch(index, end int) (int, int) {
+  limit := end - index
+  if limit > lzmaMaxMatch { limit = lzmaMaxMatch }
+  if limit < 3 { return 0, 0 }
+
+  bestLength, bestDistance := 0, 0
+  candidate := xw.head[xw.hashAt(index)]
+
+  for chain := 0; chain < xzMaxChain && candidate > 0; chain++ {
+    candidateIndex := int(candidate - 1 - xw.base)
+    if candidateIndex < 0 || candidateIndex >= index { break }
+
+    distance := index - candidateIndex
+    if distance >= xzDictSize { break }
+
+    if length := xw.matchLength(index, distance, limit); length > bestLength {
+      bestLength, bestDistance = length, distance
+      if length == limit { break }
+    }
+
+    candidate = xw.prev[candidateIndex]
+  }
+
+  return bestLength, bestDistance
+}
+
+type lzmaLengthCoder struct {
+  choice [2]uint16
+  low [lzmaPosStates][8]uint16
+  mid [lzmaPosStates][8]uint16
+  high [256]uint16
+}
+
+type lzmaEncoder struct {
+  rc lzmaRangeEncoder
+
+  state int
+  reps [4]int // distances minus one
+  isMatch [lzmaStates][lzmaPosStates]uint16
+  isRep [lzmaStates]uint16
+  isRep0 [lzmaStates]uint16
+  isRep1 [lzmaStates]uint16
+  isRep2 [lzmaStates]uint16
+  isRep0Long [lzmaStates][lzmaPosStates]uint16
+  literal [(1 << lzmaLc) * 0x300]uint16
+  distSlot [4][64]uint16
+  distSpecial [lzmaFullDistances - lzmaEndPosModelIndex]uint16
+  align [1 << lzmaAlignBits]uint16
+  matchLen lzmaLengthCoder
+  repLen lzmaLengthCoder
+}
+
+// every chunk starts with the state reset so it is encoded independently
+func (e *lzmaEncoder) reset() {
+  *e = lzmaEncoder{}
+  e.rc.reset()
+
+  probs := [][]uint16{ e.isRep[:], e.isRep0[:], e.isRep1[:], e.isRep2[:], e.literal[:], e.distSpecial[:], e.align[:] }
+  for i := range e.isMatch {
+    probs = append(probs, e.isMatch[i][:], e.isRep0Long[i][:])
+  }
+  for i := range e.distSlot {
+    probs = append(probs, e.distSlot[i][:])
+  }
+  for _, coder := range []*lzmaLengthCoder{ &e.matchLen, &e.repLen } {
+    probs = append(probs, coder.choice[:], coder.high[:])
+    for i := 0; i < lzmaPosStates; i++ {
+      probs = append(probs, coder.low[i][:], coder.mid[i][:])
+    }
+  }
+
+  for _, p := range probs {
+    for i := range p {
+      p[i] = 1024
+    }
+  }
+}
+
+func (e *lzmaEncoder) encodeChunk(xw *xzWriter, start, end int) []byte {
+  e.reset()
+  window := xw.window
+
+  for index := start; index < end; {
+    position := int(xw.base) + index
+    posState := position & (lzmaPosStates - 1)
+
+    limit := end - index
+    if limit > lzmaMaxMatch { limit = lzmaMaxMatch }
+
+    repIndex, repLength := 0, 0
+    for i, rep := range e.reps {
+      if rep + 1 > index { continue }
+      if length := xw.matchLength(index, rep + 1, limit); length > repLength {
+        repIndex, repLength = i, length
+      }
+    }
+
+    matchLength, matchDistance := xw.findMatch(index, end)
+
+    length := 1
+    if repLength >= lzmaMinMatch && repLength + 1 >= matchLength {
+      e.encodeRep(repIndex, repLength, posState)
+      length = repLength
+    } else if matchLength >= 3 && !(matchLength == 3 && matchDistance > 1 << 14) {
+      e.encodeMatch(matchDistance - 1, matchLength, posState)
+      length = matchLength
+    } else {
+      e.encodeLiteral(window, index, posState)
+    }
+
+    for i := 0; i < length; i++ {
+      xw.insertHash(index + i, end)
+    }
+    index += length
+  }
+
+  return e.rc.finish()
+}
+
+func (e *lzmaEncoder) encodeLiteral(window []byte, index, posState int) {
+  e.rc.encodeBit(&e.isMatch[e.state][posState], 0)
+
+  prevByte := 0
+  if index > 0 { prevByte = int(window[index - 1]) }
+  probs := e.literal[0x300 * (prevByte >> (8 - lzmaLc)):]
+  symbol := uint(window[index])
+
+  if e.state < 7 {
+    m := uint(1)
+    for i := 7; i >= 0; i-- {
+      bit := (symbol >> uint(i)) & 1
+      e.rc.encodeBit(&probs[m], bit)
+      m = m << 1 | bit
+    }
+  } else {
+    matchByte := uint(window[index - e.reps[0] - 1]) << 1
+    offset, m := uint(0x100), uint(1)
+    for i := 7; i >= 0; i-- {
+      bit := (symbol >> uint(i)) & 1
+      matchBit := matchByte & offset
+      matchByte <<= 1
+      e.rc.encodeBit(&probs[offset + matchBit + m], bit)
+      m = m << 1 | bit
+      if bit == 1 { offset = matchBit } else { offset &^= matchBit }
+    }
+  }
+
+  if e.state < 4 {
+    e.state = 0
+  } else if e.state < 10 {
+    e.state -= 3
+  } else {
+    e.state -= 6
+  }
+}
+
+func (e *lzmaEncoder) encodeMatch(distance, length, posState int) {
+  e.rc.encodeBit(&e.isMatch[e.state][posState], 1)
+  e.rc.encodeBit(&e.isRep[e.state], 0)
+  e.encodeLength(&e.matchLen, length, posState)
+
+  lengthState := length - lzmaMinMatch
+  if lengthState > 3 { lengthState = 3 }
+
+  slot := lzmaDistanceSlot(distance)
+  e.rc.encodeBitTree(e.distSlot[lengthState][:], uint(slot), 6)
+
+  if slot >= 4 {
+    footerBits := uint(slot >> 1) - 1
+    base := (2 | (slot & 1)) << footerBits
+    reduced := uint(distance - base)
+
+    if slot < lzmaEndPosModelIndex {
+      // probabilities of the slot start right before base - slot
+      e.rc.encodeReverseBitTree(e.distSpecial[:], base - slot - 1, reduced, footerBits)
+    } else {
+      e.rc.encodeDirectBits(reduced >> lzmaAlignBits, footerBits - lzmaAlignBits)
+      e.rc.encodeReverseBitTree(e.align[:], 0, reduced & (1 << lzmaAlignBits - 1), lzmaAlignBits)
+    }
+  }
+
+  e.reps = [4]int{ distance, e.reps[0], e.reps[1], e.reps[2] }
+  if e.state < 7 { e.state = 7 } else { e.state = 10 }
+}
+
+func (e *lzmaEncoder) encodeRep(repIndex, length, posState int) {
+  e.rc.encodeBit(&e.isMatch[e.state][posState], 1)
+  e.rc.encodeBit(&e.isRep[e.state], 1)
+
+  if repIndex == 0 {
+    e.rc.encodeBit(&e.isRep0[e.state], 0)
+    e.rc.encodeBit(&e.isRep0Long[e.state][posState], 1)
+  } else {
+    e.rc.encodeBit(&e.isRep0[e.state], 1)
+    if repIndex == 1 {
+      e.rc.encodeBit(&e.isRep1[e.state], 0)
+    } else {
+      e.rc.encodeBit(&e.isRep1[e.state], 1)
+      e.rc.encodeBit(&e.isRep2[e.state], uint(repIndex - 2))
+    }
+
+    distance := e.reps[repIndex]
+    copy(e.reps[1:repIndex + 1], e.reps[:repIndex])
+    e.reps[0] = distance
+  }
+
+  e.encodeLength(&e.repLen, length, posState)
+  if e.state < 7 { e.state = 8 } else { e.state = 11 }
+}
+
+func (e *lzmaEncoder) encodeLength(coder *lzmaLengthCoder, length, posState int) {
+  length -= lzmaMinMatch
+
+  if length < 8 {
+    e.rc.encodeBit(&coder.choice[0], 0)
+    e.rc.encodeBitTree(coder.low[posState][:], uint(length), 3)
+  } else if length < 16 {
+    e.rc.encodeBit(&coder.choice[0], 1)
+    e.rc.encodeBit(&coder.choice[1], 0)
+    e.rc.encodeBitTree(coder.mid[posState][:], uint(length - 8), 3)
+  } else {
+    e.rc.encodeBit(&coder.choice[0], 1)
+    e.rc.encodeBit(&coder.choice[1], 1)
+    e.rc.encodeBitTree(coder.high[:], uint(length - 16), 8)
+  }
+}
+
+func lzmaDistanceSlot(distance int) int {
+  if distance < 4 { return distance }
+
+  bits := 0
+  for value := distance; value > 1; value >>= 1 {
+    bits++
+  }
+
+  return bits * 2 + ((distance >> uint(bits - 1)) & 1)
+}
+
+type lzmaRangeEncoder struct {
+  out bytes.Buffer
+  low uint64
+  rng uint32
+  cache byte
+  cacheSize int64
+}
+
+func (rc *lzmaRangeEncoder) reset() {
+  rc.out.Reset()
+  rc.low, rc.rng, rc.cache, rc.cacheSize = 0, 0xFFFFFFFF, 0, 1
+}
+
+func (rc *lzmaRangeEncoder) shiftLow() {
+  if uint32(rc.low) < 0xFF000000 || rc.low >> 32 != 0 {
+    carry := byte(rc.low >> 32)
+    temp := rc.cache
+    for {
+      rc.out.WriteByte(temp + carry)
+      temp = 0xFF
+      if rc.cacheSize--; rc.cacheSize == 0 { break }
+    }
+    rc.cache = byte(rc.low >> 24)
+  }
+
+  rc.cacheSize++
+  rc.low = (rc.low & 0x00FFFFFF) << 8
+}
+
+func (rc *lzmaRangeEncoder) encodeBit(prob *uint16, bit uint) {
+  bound := (rc.rng >> 11) * uint32(*prob)
+  if bit == 0 {
+    rc.rng = bound
+    *prob += (2048 - *prob) >> 5
+  } else {
+    rc.low += uint64(bound)
+    rc.rng -= bound
+    *prob -= *prob >> 5
+  }
+
+  for rc.rng < 1 << 24 {
+    rc.rng <<= 8
+    rc.shiftLow()
+  }
+}
+
+func (rc *lzmaRangeEncoder) encodeDirectBits(value, count uint) {
+  for count > 0 {
+    count--
+    rc.rng >>= 1
+    if (value >> count) & 1 == 1 { rc.low += uint64(rc.rng) }
+
+    for rc.rng < 1 << 24 {
+      rc.rng <<= 8
+      rc.shiftLow()
+    }
+  }
+}
+
+func (rc *lzmaRangeEncoder) encodeBitTree(probs []uint16, value, bits uint) {
+  m := uint(1)
+  for i := int(bits) - 1; i >= 0; i-- {
+    bit := (value >> uint(i)) & 1
+    rc.encodeBit(&probs[m], bit)
+    m = m << 1 | bit
+  }
+}
+
+func (rc *lzmaRangeEncoder) encodeReverseBitTree(probs []uint16, offset int, value, bits uint) {
+  m := uint(1)
+  for i := uint(0); i < bits; i++ {
+    bit := (value >> i) & 1
+    rc.encodeBit(&probs[offset + int(m)], bit)
+    m = m << 1 | bit
+  }
+}
+
+func (rc *lzmaRangeEncoder) finish() []byte {
+  for i := 0; i < 5; i++ {
+    rc.shiftLow()
+  }
+
+  return append([]byte{}, rc.out.Bytes()...)
+}